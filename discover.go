@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"kube-ssm-proxy/internal/aws"
+	"kube-ssm-proxy/internal/config"
+)
+
+// runDiscover implements `kube-ssm-proxy discover`. It lists the EKS
+// clusters reachable with each profile in each region and prints them as
+// clusters.yaml entries, or with --write appends the ones not yet
+// configured to the config file.
+func runDiscover(configPath string, args []string) int {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	profiles := fs.String("profiles", "", "comma-separated AWS profiles to scan (required)")
+	regions := fs.String("regions", "", "comma-separated AWS regions to scan (required)")
+	bastionTag := fs.String("bastion-tag", "Purpose=bastion", "EC2 tag used to look for a bastion")
+	write := fs.Bool("write", false, "append new clusters to the config file instead of printing them")
+	fs.Parse(args)
+
+	if *profiles == "" || *regions == "" {
+		fmt.Fprintf(os.Stderr, "%sdiscover requires --profiles and --regions%s\n", red, reset)
+		return 2
+	}
+	tagKey, tagValue, ok := strings.Cut(*bastionTag, "=")
+	if !ok || tagKey == "" {
+		fmt.Fprintf(os.Stderr, "%sinvalid --bastion-tag %q: expected key=value format%s\n", red, *bastionTag, reset)
+		return 2
+	}
+	bastion := aws.BastionSelector{
+		Filters:  []aws.BastionFilter{aws.TagFilter(tagKey, tagValue)},
+		Strategy: aws.StrategyNewest,
+	}
+
+	var found []config.ClusterConfig
+	failed := false
+	for _, profile := range splitList(*profiles) {
+		for _, region := range splitList(*regions) {
+			infos, err := aws.ListClusters(profile, region)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%s/%s: %v%s\n", yellow, profile, region, err, reset)
+				failed = true
+				continue
+			}
+			for _, info := range infos {
+				found = append(found, discoveredCluster(info, *bastionTag, bastion))
+			}
+		}
+	}
+	log.Printf("Discovered %d clusters", len(found))
+
+	if !*write {
+		out, err := config.MarshalClusters(found)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, reset)
+			return 1
+		}
+		fmt.Print(string(out))
+		return exitCode(failed)
+	}
+
+	path, err := config.ResolvePath(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, reset)
+		return 1
+	}
+	added, err := config.MergeClusters(path, found)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to update %s: %v%s\n", red, path, err, reset)
+		return 1
+	}
+	for _, c := range added {
+		fmt.Printf("  %s+%s %s (%s, %s)\n", green, reset, c.Name, c.Region, c.Profile)
+	}
+	fmt.Printf("%sAdded %d new cluster(s) to %s%s\n", green, len(added), path, reset)
+	return exitCode(failed)
+}
+
+// discoveredCluster turns an EKS cluster into a config entry. Clusters with
+// a public endpoint open to everyone connect directly; others go through a
+// bastion, which is looked up so a missing one is reported now rather than
+// at connect time. A public endpoint limited to some source ranges is noted
+// in the entry, since whether it is reachable depends on where kubectl runs.
+func discoveredCluster(info aws.ClusterInfo, bastionTag string, bastion aws.BastionSelector) config.ClusterConfig {
+	useBastion := info.NeedsBastion()
+	c := config.ClusterConfig{
+		Name:        info.Name,
+		Region:      info.Region,
+		ClusterName: info.Name,
+		Profile:     info.Profile,
+		UseBastion:  &useBastion,
+	}
+	if info.PublicAccessRestricted() {
+		cidrs := strings.Join(info.PublicAccessCIDRs, ", ")
+		if useBastion {
+			c.Comment = fmt.Sprintf("Public endpoint limited to %s; using the private endpoint through a bastion.", cidrs)
+		} else {
+			c.Comment = fmt.Sprintf("Public endpoint limited to %s and the private endpoint is disabled; direct access only works from those ranges.", cidrs)
+			fmt.Fprintf(os.Stderr, "%swarning: %s only accepts API connections from %s%s\n", yellow, info.Name, cidrs, reset)
+		}
+	}
+	if useBastion {
+		if _, err := aws.FindBastion(aws.Target{Profile: info.Profile, Region: info.Region}, bastion.InVPC(info.VpcID)); err != nil {
+			fmt.Fprintf(os.Stderr, "%swarning: %s has a private endpoint but no bastion was found: %v%s\n",
+				yellow, info.Name, err, reset)
+		}
+		if bastionTag != "Purpose=bastion" {
+			c.BastionTag = bastionTag
+		}
+	}
+	return c
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func exitCode(failed bool) int {
+	if failed {
+		return 1
+	}
+	return 0
+}
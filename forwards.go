@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"kube-ssm-proxy/internal/aws"
+	"kube-ssm-proxy/internal/config"
+	"kube-ssm-proxy/internal/kubeconfig"
+	"kube-ssm-proxy/internal/ssm"
+)
+
+// startExtraForwards opens the cluster's configured extra forwards through
+// its bastion, skipping any whose target already has a running forward.
+// bastionID may be empty (e.g. when the API tunnel was reused); the bastion
+// is then looked up only if something actually needs starting. Failures are
+// reported but not fatal, since the API tunnel is already up.
+func startExtraForwards(cluster *config.ClusterConfig, bastionID string, cfg config.Config) {
+	if len(cluster.Forwards) == 0 {
+		return
+	}
+	portRange := ssm.PortRange{Min: cfg.PortRange.Min, Max: cfg.PortRange.Max}
+
+	existing, _ := ssm.ListForwards()
+	bastion := bastionTarget(cluster)
+	for _, fc := range cluster.Forwards {
+		host, port := fc.Host, fc.RemotePort
+		if fc.Resource != "" {
+			var resPort int
+			var err error
+			host, resPort, err = aws.ResolveResource(clusterTarget(cluster), fc.Resource)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s⚠ Forward %s: %v%s\n", yellow, fc.Name, err, reset)
+				continue
+			}
+			if port == 0 {
+				port = resPort
+			}
+		}
+
+		if f, ok := findForward(existing, host, port); ok {
+			fmt.Printf("%s  ↳ %s: localhost:%d -> %s:%d (reused)%s\n", green, fc.Name, f.LocalPort, host, port, reset)
+			continue
+		}
+
+		// The bastion is only looked up once something has to be started.
+		if bastionID == "" {
+			var err error
+			bastionID, err = findClusterBastion(cluster, cfg.FzfHeight, true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s⚠ Skipping extra forwards: failed to find bastion: %v%s\n", yellow, err, reset)
+				return
+			}
+		}
+
+		local, err := startForwardWithRetry(ssm.ForwardOptions{
+			ClusterName:   cluster.Name,
+			Name:          fc.Name,
+			BastionID:     bastionID,
+			TargetHost:    host,
+			TargetPort:    port,
+			LocalPort:     fc.LocalPort,
+			Profile:       bastion.Profile,
+			Region:        bastion.Region,
+			Document:      cluster.SSMDocument,
+			Parameters:    cluster.SSMDocumentParameters,
+			PortSeed:      cluster.Name + "/" + fc.Name,
+			PortRange:     portRange,
+			ReservedPorts: kubeconfig.PortsInUse(""),
+			MarkInactive:  kubeconfig.MarkPortInactive,
+			Role:          bastion.Role,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s⚠ Forward %s failed: %v%s\n", yellow, fc.Name, err, reset)
+			continue
+		}
+		log.Printf("Extra forward %s ready on port %d", fc.Name, local)
+		fmt.Printf("%s  ↳ %s: localhost:%d -> %s:%d%s\n", green, fc.Name, local, host, port, reset)
+	}
+}
+
+// findClusterBastion describes the cluster (for its VPC) and looks up its
+// bastion; see findBastion.
+func findClusterBastion(cluster *config.ClusterConfig, fzfHeight string, interactive bool) (string, error) {
+	info, err := aws.DescribeCluster(clusterTarget(cluster), cluster.ClusterName)
+	if err != nil {
+		return "", err
+	}
+	return findBastion(cluster, info, fzfHeight, interactive)
+}
+
+func findForward(forwards []ssm.Forward, host string, port int) (ssm.Forward, bool) {
+	for _, f := range forwards {
+		if f.TargetHost == host && f.TargetPort == port {
+			return f, true
+		}
+	}
+	return ssm.Forward{}, false
+}
+
+// extraForwardLabel returns "cluster/forward" for a running forward that
+// matches a configured extra forward, or "" if none does. Host forwards
+// match exactly; resource forwards match when the resource identifier is
+// one of the first two DNS labels of the target (as in RDS and ElastiCache
+// endpoint names), which avoids an AWS call just to draw the list.
+func extraForwardLabel(clusters []config.ClusterConfig, f ssm.Forward) string {
+	labels := strings.SplitN(f.TargetHost, ".", 3)
+	for _, c := range clusters {
+		for _, fc := range c.Forwards {
+			if fc.RemotePort != 0 && fc.RemotePort != f.TargetPort {
+				continue
+			}
+			match := fc.Host != "" && fc.Host == f.TargetHost
+			if fc.Resource != "" {
+				_, id, _ := strings.Cut(fc.Resource, ":")
+				for _, l := range labels[:min(2, len(labels))] {
+					if l == id {
+						match = true
+					}
+				}
+			}
+			if match {
+				return c.Name + "/" + fc.Name
+			}
+		}
+	}
+	return ""
+}
@@ -8,9 +8,6 @@ import (
 	"os/exec"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
 )
 
@@ -54,15 +51,12 @@ func (e *AuthError) Error() string {
 	return msg
 }
 
-// DescribeCluster returns the EKS cluster endpoint URL.
-func DescribeCluster(profile, region, clusterName string) (string, error) {
+// DescribeCluster returns the EKS cluster's endpoint URL and VPC settings.
+func DescribeCluster(t Target, clusterName string) (ClusterInfo, error) {
 	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithSharedConfigProfile(profile),
-		config.WithRegion(region),
-	)
+	cfg, err := loadConfig(ctx, t)
 	if err != nil {
-		return "", fmt.Errorf("load aws config: %w", err)
+		return ClusterInfo{}, err
 	}
 
 	client := eks.NewFromConfig(cfg)
@@ -70,63 +64,16 @@ func DescribeCluster(profile, region, clusterName string) (string, error) {
 		Name: &clusterName,
 	})
 	if err != nil {
-		return "", fmt.Errorf("describe cluster %s: %w", clusterName, err)
+		return ClusterInfo{}, fmt.Errorf("describe cluster %s: %w", clusterName, err)
 	}
-	if out.Cluster == nil || out.Cluster.Endpoint == nil {
-		return "", fmt.Errorf("cluster %s has no endpoint", clusterName)
+	info := clusterInfo(out.Cluster)
+	if info.Endpoint == "" {
+		return ClusterInfo{}, fmt.Errorf("cluster %s has no endpoint", clusterName)
 	}
+	info.Name, info.Region, info.Profile = clusterName, t.Region, t.Profile
 
-	endpoint := *out.Cluster.Endpoint
-	log.Printf("EKS endpoint for %s: %s", clusterName, endpoint)
-	return endpoint, nil
-}
-
-// FindBastion discovers the single running EC2 instance matching bastionTag
-// (formatted as "key=value") in the given region.
-func FindBastion(profile, region, bastionTag string) (string, error) {
-	tagKey, tagValue, ok := strings.Cut(bastionTag, "=")
-	if !ok {
-		return "", fmt.Errorf("invalid bastion_tag %q: expected key=value format", bastionTag)
-	}
-
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithSharedConfigProfile(profile),
-		config.WithRegion(region),
-	)
-	if err != nil {
-		return "", fmt.Errorf("load aws config: %w", err)
-	}
-
-	client := ec2.NewFromConfig(cfg)
-	out, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-		Filters: []ec2types.Filter{
-			{Name: strPtr("tag:" + tagKey), Values: []string{tagValue}},
-			{Name: strPtr("instance-state-name"), Values: []string{"running"}},
-		},
-	})
-	if err != nil {
-		return "", fmt.Errorf("describe instances: %w", err)
-	}
-
-	var instances []string
-	for _, r := range out.Reservations {
-		for _, inst := range r.Instances {
-			if inst.InstanceId != nil {
-				instances = append(instances, *inst.InstanceId)
-			}
-		}
-	}
-
-	switch len(instances) {
-	case 0:
-		return "", fmt.Errorf("no bastion instance found in %s", region)
-	case 1:
-		log.Printf("Found bastion: %s", instances[0])
-		return instances[0], nil
-	default:
-		return "", fmt.Errorf("expected 1 bastion in %s, found %d", region, len(instances))
-	}
+	log.Printf("EKS endpoint for %s: %s (VPC %s)", clusterName, info.Endpoint, info.VpcID)
+	return info, nil
 }
 
 // --- helpers ---
@@ -158,18 +105,21 @@ func getCallerIdentity(profile string) (*AuthInfo, error) {
 	}, nil
 }
 
+// extractRoleName returns the IAM role name from an STS caller ARN in any
+// partition, e.g. arn:aws-us-gov:sts::123456789012:assumed-role/Admin/session
+// or arn:aws-cn:iam::123456789012:role/path/Admin.
 func extractRoleName(arn string) string {
-	if idx := strings.Index(arn, "assumed-role/"); idx >= 0 {
-		parts := strings.Split(arn[idx+len("assumed-role/"):], "/")
-		if len(parts) > 0 {
-			return parts[0]
-		}
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return ""
 	}
-	if idx := strings.Index(arn, "role/"); idx >= 0 {
-		parts := strings.Split(arn[idx+len("role/"):], "/")
-		if len(parts) > 0 {
-			return parts[0]
-		}
+	resource := parts[5]
+	if rest, ok := strings.CutPrefix(resource, "assumed-role/"); ok {
+		name, _, _ := strings.Cut(rest, "/")
+		return name
+	}
+	if rest, ok := strings.CutPrefix(resource, "role/"); ok {
+		return rest[strings.LastIndex(rest, "/")+1:]
 	}
 	return ""
 }
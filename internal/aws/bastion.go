@@ -0,0 +1,412 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Strategies for choosing among several bastions matching a selector.
+const (
+	StrategySingle    = "single"     // require exactly one match
+	StrategySSMOnline = "ssm-online" // online agent that pinged most recently, then newest
+	StrategySameAZ    = "same-az"    // newest instance in a control-plane AZ
+	StrategyNewest    = "newest"     // latest launch time
+	StrategyRandom    = "random"
+	StrategyPick      = "pick" // ask via BastionSelector.Pick
+)
+
+// BastionSelector identifies the bastion for FindBastion: an explicit
+// instance ID, or EC2 DescribeInstances filters that must all match.
+type BastionSelector struct {
+	InstanceID string
+	Filters    []BastionFilter
+
+	// Strategy chooses among several matches; StrategySingle if empty.
+	Strategy string
+	// ControlPlaneSubnets are the cluster's subnets, used by StrategySameAZ.
+	ControlPlaneSubnets []string
+	// Pick asks the user to choose for StrategyPick. Candidates are ordered
+	// newest first; without Pick the newest is used.
+	Pick func([]Bastion) (Bastion, error)
+}
+
+// BastionFilter is one EC2 instance filter, e.g. {"tag:Purpose", ["bastion"]}
+// or {"vpc-id", ["vpc-0123"]}. It matches any of its values.
+type BastionFilter struct {
+	Name   string
+	Values []string
+}
+
+// Bastion is a running instance matching a BastionSelector.
+type Bastion struct {
+	InstanceID       string
+	Name             string // Name tag, if any
+	AvailabilityZone string
+	LaunchTime       time.Time
+
+	// SSM agent state from DescribeInstanceInformation; PingStatus is empty
+	// if the instance is not registered with SSM.
+	PingStatus   string
+	AgentVersion string
+	LastPing     time.Time
+}
+
+func (b Bastion) String() string {
+	s := b.InstanceID
+	if b.Name != "" {
+		s += " (" + b.Name + ")"
+	}
+	return fmt.Sprintf("%s in %s, launched %s", s, b.AvailabilityZone, b.LaunchTime.Format(time.DateTime))
+}
+
+func (b Bastion) online() bool {
+	return b.PingStatus == string(ssmtypes.PingStatusOnline)
+}
+
+// agentSummary describes the instance's SSM agent for error messages.
+func (b Bastion) agentSummary() string {
+	if b.PingStatus == "" {
+		return "not registered with SSM"
+	}
+	s := b.PingStatus
+	if b.AgentVersion != "" {
+		s += ", agent " + b.AgentVersion
+	}
+	if !b.LastPing.IsZero() {
+		s += fmt.Sprintf(", last ping %s (%s ago)", b.LastPing.Local().Format(time.DateTime),
+			time.Since(b.LastPing).Truncate(time.Second))
+	}
+	return s
+}
+
+// TagFilter returns the filter matching instances tagged key=value.
+func TagFilter(key, value string) BastionFilter {
+	return BastionFilter{Name: "tag:" + key, Values: []string{value}}
+}
+
+// InVPC returns s restricted to instances in vpcID, unless s already names
+// an instance or a VPC.
+func (s BastionSelector) InVPC(vpcID string) BastionSelector {
+	if vpcID == "" || s.InstanceID != "" || s.has("vpc-id") {
+		return s
+	}
+	s.Filters = append(slices.Clip(s.Filters), BastionFilter{Name: "vpc-id", Values: []string{vpcID}})
+	return s
+}
+
+// InSubnets returns s restricted to instances in one of subnetIDs, unless s
+// already names an instance or a subnet.
+func (s BastionSelector) InSubnets(subnetIDs []string) BastionSelector {
+	if len(subnetIDs) == 0 || s.InstanceID != "" || s.has("subnet-id") {
+		return s
+	}
+	s.Filters = append(slices.Clip(s.Filters), BastionFilter{Name: "subnet-id", Values: subnetIDs})
+	return s
+}
+
+func (s BastionSelector) has(name string) bool {
+	for _, f := range s.Filters {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s BastionSelector) String() string {
+	if s.InstanceID != "" {
+		return s.InstanceID
+	}
+	parts := make([]string, len(s.Filters))
+	for i, f := range s.Filters {
+		parts[i] = f.Name + "=" + strings.Join(f.Values, "|")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FindBastion returns the running EC2 instance matching sel in the target's
+// region. Instances whose SSM agent is not online are skipped, since a
+// session to them would fail with TargetNotConnected. When several remain,
+// sel.Strategy decides which one is used.
+func FindBastion(t Target, sel BastionSelector) (string, error) {
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return "", err
+	}
+
+	running, err := listBastions(ctx, cfg, sel, "running")
+	if err != nil {
+		return "", err
+	}
+	if len(running) == 0 {
+		stopped, err := listBastions(ctx, cfg, sel, "stopped")
+		if err == nil && len(stopped) > 0 {
+			return "", &StoppedBastionError{Selector: sel.String(), Region: t.Region, Stopped: stopped}
+		}
+		return "", fmt.Errorf("no running bastion instance matching %s found in %s", sel, t.Region)
+	}
+
+	candidates, err := onlineBastions(ctx, cfg, running)
+	if err != nil {
+		// Without ssm:DescribeInstanceInformation we cannot tell; let the
+		// session attempt find out.
+		log.Printf("Warning: cannot query SSM agent status, not checking bastions: %v", err)
+		candidates = running
+	}
+
+	switch len(candidates) {
+	case 0:
+		lines := make([]string, len(running))
+		for i, b := range running {
+			lines[i] = fmt.Sprintf("  %s: %s", b.InstanceID, b.agentSummary())
+		}
+		return "", fmt.Errorf("no bastion matching %s in %s has an online SSM agent:\n%s",
+			sel, t.Region, strings.Join(lines, "\n"))
+	case 1:
+		log.Printf("Found bastion: %s", candidates[0])
+		return candidates[0].InstanceID, nil
+	}
+
+	b, err := chooseBastion(ctx, cfg, sel, candidates)
+	if err != nil {
+		return "", fmt.Errorf("%d bastions match %s in %s: %w", len(candidates), sel, t.Region, err)
+	}
+	log.Printf("Found %d bastions, chose %s (strategy %s)", len(candidates), b, sel.Strategy)
+	return b.InstanceID, nil
+}
+
+// StoppedBastionError is returned by FindBastion when no matching instance
+// is running but some are stopped, so the caller can offer to start one.
+type StoppedBastionError struct {
+	Selector string
+	Region   string
+	Stopped  []Bastion // newest first
+}
+
+func (e *StoppedBastionError) Error() string {
+	return fmt.Sprintf("no running bastion matching %s in %s; %s is stopped (set auto_start_bastion: true to start it automatically)",
+		e.Selector, e.Region, e.Stopped[0].InstanceID)
+}
+
+// listBastions returns every instance in state matching sel, newest first.
+func listBastions(ctx context.Context, cfg awssdk.Config, sel BastionSelector, state string) ([]Bastion, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{
+			{Name: strPtr("instance-state-name"), Values: []string{state}},
+		},
+	}
+	if sel.InstanceID != "" {
+		input.InstanceIds = []string{sel.InstanceID}
+	}
+	for _, f := range sel.Filters {
+		input.Filters = append(input.Filters, ec2types.Filter{Name: strPtr(f.Name), Values: f.Values})
+	}
+
+	var bastions []Bastion
+	pager := ec2.NewDescribeInstancesPaginator(ec2.NewFromConfig(cfg), input)
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describe instances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, inst := range r.Instances {
+				if inst.InstanceId == nil {
+					continue
+				}
+				b := Bastion{InstanceID: *inst.InstanceId}
+				if inst.Placement != nil && inst.Placement.AvailabilityZone != nil {
+					b.AvailabilityZone = *inst.Placement.AvailabilityZone
+				}
+				if inst.LaunchTime != nil {
+					b.LaunchTime = *inst.LaunchTime
+				}
+				for _, tag := range inst.Tags {
+					if tag.Key != nil && *tag.Key == "Name" && tag.Value != nil {
+						b.Name = *tag.Value
+					}
+				}
+				bastions = append(bastions, b)
+			}
+		}
+	}
+
+	slices.SortFunc(bastions, func(a, b Bastion) int {
+		return b.LaunchTime.Compare(a.LaunchTime)
+	})
+	return bastions, nil
+}
+
+// chooseBastion applies sel.Strategy to two or more candidates ordered
+// newest first.
+func chooseBastion(ctx context.Context, cfg awssdk.Config, sel BastionSelector, candidates []Bastion) (Bastion, error) {
+	switch sel.Strategy {
+	case StrategyNewest:
+		return candidates[0], nil
+
+	case StrategyRandom:
+		return candidates[rand.IntN(len(candidates))], nil
+
+	case StrategyPick:
+		if sel.Pick == nil {
+			return candidates[0], nil
+		}
+		return sel.Pick(candidates)
+
+	case StrategySameAZ:
+		zones, err := subnetZones(ctx, cfg, sel.ControlPlaneSubnets)
+		if err != nil {
+			log.Printf("Warning: cannot look up control-plane AZs, using newest bastion: %v", err)
+			return candidates[0], nil
+		}
+		for _, b := range candidates {
+			if zones[b.AvailabilityZone] {
+				return b, nil
+			}
+		}
+		log.Printf("No bastion in a control-plane AZ, using newest")
+		return candidates[0], nil
+
+	case StrategySSMOnline:
+		// Candidates are all online unless the agent status could not be
+		// queried; then the ping times are unknown and the newest wins.
+		best := candidates[0]
+		for _, b := range candidates[1:] {
+			if b.online() != best.online() {
+				if b.online() {
+					best = b
+				}
+				continue
+			}
+			if b.LastPing.After(best.LastPing) {
+				best = b
+			}
+		}
+		return best, nil
+
+	default:
+		ids := make([]string, len(candidates))
+		for i, b := range candidates {
+			ids[i] = b.InstanceID
+		}
+		return Bastion{}, fmt.Errorf("expected 1 (%s); narrow the bastion selector or set bastion_strategy",
+			strings.Join(ids, ", "))
+	}
+}
+
+// subnetZones returns the availability zones of subnetIDs.
+func subnetZones(ctx context.Context, cfg awssdk.Config, subnetIDs []string) (map[string]bool, error) {
+	zones := make(map[string]bool)
+	if len(subnetIDs) == 0 {
+		return zones, nil
+	}
+	out, err := ec2.NewFromConfig(cfg).DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: subnetIDs})
+	if err != nil {
+		return nil, fmt.Errorf("describe subnets: %w", err)
+	}
+	for _, s := range out.Subnets {
+		if s.AvailabilityZone != nil {
+			zones[*s.AvailabilityZone] = true
+		}
+	}
+	return zones, nil
+}
+
+// onlineBastions fills in the SSM agent state of each candidate and returns
+// those whose agent is online, keeping their order. The candidates slice is
+// updated in place so callers can report why the others were skipped.
+func onlineBastions(ctx context.Context, cfg awssdk.Config, candidates []Bastion) ([]Bastion, error) {
+	ids := make([]string, len(candidates))
+	for i, b := range candidates {
+		ids[i] = b.InstanceID
+	}
+	infos, err := agentInfo(ctx, cfg, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var online []Bastion
+	for i := range candidates {
+		b := &candidates[i]
+		info, ok := infos[b.InstanceID]
+		if !ok {
+			continue
+		}
+		b.PingStatus = string(info.PingStatus)
+		if info.AgentVersion != nil {
+			b.AgentVersion = *info.AgentVersion
+		}
+		if info.LastPingDateTime != nil {
+			b.LastPing = *info.LastPingDateTime
+		}
+		if info.PingStatus == ssmtypes.PingStatusOnline {
+			online = append(online, *b)
+		} else {
+			log.Printf("Skipping bastion %s: SSM agent %s", b.InstanceID, b.agentSummary())
+		}
+	}
+	return online, nil
+}
+
+// agentInfo returns the SSM registration of each of ids that has one.
+func agentInfo(ctx context.Context, cfg awssdk.Config, ids []string) (map[string]ssmtypes.InstanceInformation, error) {
+	infos := make(map[string]ssmtypes.InstanceInformation)
+	pager := ssm.NewDescribeInstanceInformationPaginator(ssm.NewFromConfig(cfg), &ssm.DescribeInstanceInformationInput{
+		Filters: []ssmtypes.InstanceInformationStringFilter{
+			{Key: strPtr("InstanceIds"), Values: ids},
+		},
+	})
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describe instance information: %w", err)
+		}
+		for _, info := range page.InstanceInformationList {
+			if info.InstanceId != nil {
+				infos[*info.InstanceId] = info
+			}
+		}
+	}
+	return infos, nil
+}
+
+// CheckDocument verifies that the SSM session document name exists in the
+// target's account and region. StartSession always runs a document's
+// default version, so a pinned version (a number or "$LATEST") must be the
+// default one; otherwise the session would silently run something else.
+func CheckDocument(t Target, name, version string) error {
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return err
+	}
+	out, err := ssm.NewFromConfig(cfg).DescribeDocument(ctx, &ssm.DescribeDocumentInput{Name: &name})
+	if err != nil {
+		return fmt.Errorf("describe document %s: %w", name, err)
+	}
+	if out.Document == nil || version == "" || version == "$DEFAULT" {
+		return nil
+	}
+	def := awssdk.ToString(out.Document.DefaultVersion)
+	want := version
+	if version == "$LATEST" {
+		want = awssdk.ToString(out.Document.LatestVersion)
+	}
+	if want != def {
+		return fmt.Errorf("document %s: ssm_document_version %s is not the default version (%s), and sessions always run the default",
+			name, version, def)
+	}
+	return nil
+}
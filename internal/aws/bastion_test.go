@@ -0,0 +1,48 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+func TestChooseBastion(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	online := func(id string, ago time.Duration) Bastion {
+		return Bastion{InstanceID: id, PingStatus: string(ssmtypes.PingStatusOnline), LastPing: now.Add(-ago)}
+	}
+	unknown := func(id string) Bastion { return Bastion{InstanceID: id} }
+
+	tests := []struct {
+		name       string
+		strategy   string
+		candidates []Bastion // newest first
+		want       string
+	}{
+		{"latest ping wins", StrategySSMOnline,
+			[]Bastion{online("i-new", 5*time.Minute), online("i-old", time.Minute)}, "i-old"},
+		{"equal pings keep newest", StrategySSMOnline,
+			[]Bastion{online("i-new", time.Minute), online("i-old", time.Minute)}, "i-new"},
+		{"online beats unknown", StrategySSMOnline,
+			[]Bastion{unknown("i-new"), online("i-old", time.Hour)}, "i-old"},
+		{"all unknown falls back to newest", StrategySSMOnline,
+			[]Bastion{unknown("i-new"), unknown("i-old")}, "i-new"},
+		{"newest ignores pings", StrategyNewest,
+			[]Bastion{online("i-new", time.Hour), online("i-old", time.Minute)}, "i-new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel := BastionSelector{Strategy: tt.strategy}
+			got, err := chooseBastion(context.Background(), awssdk.Config{}, sel, tt.candidates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.InstanceID != tt.want {
+				t.Errorf("chose %s, want %s", got.InstanceID, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// ClusterInfo summarises an EKS cluster found by ListClusters.
+type ClusterInfo struct {
+	Name          string
+	Region        string
+	Profile       string
+	Endpoint      string
+	PublicAccess  bool
+	PrivateAccess bool
+	// PublicAccessCIDRs are the source ranges the public endpoint accepts;
+	// EKS reports 0.0.0.0/0 when it is open to everyone.
+	PublicAccessCIDRs []string
+	VpcID             string
+	SubnetIDs         []string // subnets the control-plane ENIs are placed in
+}
+
+// PublicAccessRestricted reports whether the public endpoint only accepts
+// some source ranges, which the caller may or may not be in.
+func (c ClusterInfo) PublicAccessRestricted() bool {
+	if !c.PublicAccess {
+		return false
+	}
+	for _, cidr := range c.PublicAccessCIDRs {
+		if cidr == "0.0.0.0/0" {
+			return false
+		}
+	}
+	return len(c.PublicAccessCIDRs) > 0
+}
+
+// NeedsBastion reports whether the cluster's API endpoint should be reached
+// through a bastion: the public endpoint is disabled, or it is limited to
+// some source ranges while the private endpoint is enabled, since the
+// bastion reaches the private endpoint from wherever the caller is.
+func (c ClusterInfo) NeedsBastion() bool {
+	return !c.PublicAccess || (c.PublicAccessRestricted() && c.PrivateAccess)
+}
+
+// ListClusters returns every EKS cluster visible to profile in region,
+// described so that endpoint access settings are available.
+func ListClusters(profile, region string) ([]ClusterInfo, error) {
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, Target{Profile: profile, Region: region})
+	if err != nil {
+		return nil, err
+	}
+
+	client := eks.NewFromConfig(cfg)
+	var names []string
+	pager := eks.NewListClustersPaginator(client, &eks.ListClustersInput{})
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list clusters in %s: %w", region, err)
+		}
+		names = append(names, page.Clusters...)
+	}
+
+	infos := make([]ClusterInfo, 0, len(names))
+	for _, name := range names {
+		out, err := client.DescribeCluster(ctx, &eks.DescribeClusterInput{Name: &name})
+		if err != nil {
+			return nil, fmt.Errorf("describe cluster %s: %w", name, err)
+		}
+		info := clusterInfo(out.Cluster)
+		info.Name, info.Region, info.Profile = name, region, profile
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// clusterInfo extracts the endpoint and VPC settings of a described cluster.
+func clusterInfo(c *ekstypes.Cluster) ClusterInfo {
+	var info ClusterInfo
+	if c == nil {
+		return info
+	}
+	if c.Endpoint != nil {
+		info.Endpoint = *c.Endpoint
+	}
+	if v := c.ResourcesVpcConfig; v != nil {
+		info.PublicAccess = v.EndpointPublicAccess
+		info.PrivateAccess = v.EndpointPrivateAccess
+		info.PublicAccessCIDRs = v.PublicAccessCidrs
+		if v.VpcId != nil {
+			info.VpcID = *v.VpcId
+		}
+		info.SubnetIDs = v.SubnetIds
+	}
+	return info
+}
@@ -0,0 +1,57 @@
+package aws
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Profiles returns the set of profile names defined in the shared AWS
+// config and credentials files ($AWS_CONFIG_FILE / $AWS_SHARED_CREDENTIALS_FILE,
+// defaulting to ~/.aws/config and ~/.aws/credentials). Missing files are
+// treated as empty.
+func Profiles() map[string]bool {
+	profiles := make(map[string]bool)
+
+	home, _ := os.UserHomeDir()
+	configPath := os.Getenv("AWS_CONFIG_FILE")
+	if configPath == "" {
+		configPath = filepath.Join(home, ".aws", "config")
+	}
+	credsPath := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credsPath == "" {
+		credsPath = filepath.Join(home, ".aws", "credentials")
+	}
+
+	for _, section := range iniSections(configPath) {
+		if section == "default" {
+			profiles[section] = true
+		} else if name, ok := strings.CutPrefix(section, "profile "); ok {
+			profiles[strings.TrimSpace(name)] = true
+		}
+	}
+	for _, section := range iniSections(credsPath) {
+		profiles[section] = true
+	}
+	return profiles
+}
+
+// iniSections returns the [section] headers of an INI file.
+func iniSections(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var sections []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			sections = append(sections, strings.TrimSpace(line[1:len(line)-1]))
+		}
+	}
+	return sections
+}
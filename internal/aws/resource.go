@@ -0,0 +1,105 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticache"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+)
+
+// ResolveResource looks up the endpoint of an AWS resource reference:
+//
+//   - rds:<db-instance-identifier>       RDS instance endpoint
+//   - rds-cluster:<db-cluster-identifier> Aurora cluster (writer) endpoint
+//   - elasticache:<replication-group-id>  primary or configuration endpoint,
+//     falling back to a cache cluster with that ID
+func ResolveResource(t Target, ref string) (string, int, error) {
+	kind, id, ok := strings.Cut(ref, ":")
+	if !ok || id == "" {
+		return "", 0, fmt.Errorf("invalid resource %q: expected kind:identifier", ref)
+	}
+
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch kind {
+	case "rds":
+		out, err := rds.NewFromConfig(cfg).DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: &id,
+		})
+		if err != nil {
+			return "", 0, fmt.Errorf("describe db instance %s: %w", id, err)
+		}
+		if len(out.DBInstances) == 0 || out.DBInstances[0].Endpoint == nil ||
+			out.DBInstances[0].Endpoint.Address == nil {
+			return "", 0, fmt.Errorf("db instance %s has no endpoint", id)
+		}
+		ep := out.DBInstances[0].Endpoint
+		return *ep.Address, int32Value(ep.Port), nil
+
+	case "rds-cluster":
+		out, err := rds.NewFromConfig(cfg).DescribeDBClusters(ctx, &rds.DescribeDBClustersInput{
+			DBClusterIdentifier: &id,
+		})
+		if err != nil {
+			return "", 0, fmt.Errorf("describe db cluster %s: %w", id, err)
+		}
+		if len(out.DBClusters) == 0 || out.DBClusters[0].Endpoint == nil {
+			return "", 0, fmt.Errorf("db cluster %s has no endpoint", id)
+		}
+		c := out.DBClusters[0]
+		return *c.Endpoint, int32Value(c.Port), nil
+
+	case "elasticache":
+		client := elasticache.NewFromConfig(cfg)
+		rg, err := client.DescribeReplicationGroups(ctx, &elasticache.DescribeReplicationGroupsInput{
+			ReplicationGroupId: &id,
+		})
+		if err == nil && len(rg.ReplicationGroups) > 0 {
+			g := rg.ReplicationGroups[0]
+			if ep := g.ConfigurationEndpoint; ep != nil && ep.Address != nil {
+				return *ep.Address, int32Value(ep.Port), nil
+			}
+			for _, ng := range g.NodeGroups {
+				if ep := ng.PrimaryEndpoint; ep != nil && ep.Address != nil {
+					return *ep.Address, int32Value(ep.Port), nil
+				}
+			}
+		}
+
+		showNodes := true
+		cc, err := client.DescribeCacheClusters(ctx, &elasticache.DescribeCacheClustersInput{
+			CacheClusterId:    &id,
+			ShowCacheNodeInfo: &showNodes,
+		})
+		if err != nil {
+			return "", 0, fmt.Errorf("describe elasticache %s: %w", id, err)
+		}
+		for _, c := range cc.CacheClusters {
+			if ep := c.ConfigurationEndpoint; ep != nil && ep.Address != nil {
+				return *ep.Address, int32Value(ep.Port), nil
+			}
+			for _, n := range c.CacheNodes {
+				if ep := n.Endpoint; ep != nil && ep.Address != nil {
+					return *ep.Address, int32Value(ep.Port), nil
+				}
+			}
+		}
+		return "", 0, fmt.Errorf("elasticache %s has no endpoint", id)
+
+	default:
+		return "", 0, fmt.Errorf("invalid resource %q: unknown kind %q", ref, kind)
+	}
+}
+
+func int32Value(p *int32) int {
+	if p == nil {
+		return 0
+	}
+	return int(*p)
+}
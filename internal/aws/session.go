@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Session is a started SSM session: where its data channel lives and the
+// token that authenticates it.
+type Session struct {
+	ID        string
+	StreamURL string
+	Token     string
+}
+
+// StartSession starts an SSM session on instanceID with the given document
+// and parameters.
+func StartSession(ctx context.Context, t Target, instanceID, document string, params map[string][]string) (Session, error) {
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return Session{}, err
+	}
+	out, err := ssm.NewFromConfig(cfg).StartSession(ctx, &ssm.StartSessionInput{
+		Target:       &instanceID,
+		DocumentName: &document,
+		Parameters:   params,
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("start session on %s: %w", instanceID, err)
+	}
+	return Session{
+		ID:        awssdk.ToString(out.SessionId),
+		StreamURL: awssdk.ToString(out.StreamUrl),
+		Token:     awssdk.ToString(out.TokenValue),
+	}, nil
+}
+
+// TerminateSession ends an SSM session so it does not linger until its
+// idle timeout.
+func TerminateSession(ctx context.Context, t Target, id string) error {
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return err
+	}
+	if _, err := ssm.NewFromConfig(cfg).TerminateSession(ctx, &ssm.TerminateSessionInput{
+		SessionId: &id,
+	}); err != nil {
+		return fmt.Errorf("terminate session %s: %w", id, err)
+	}
+	return nil
+}
+
+// GenerateDataKey returns a 64-byte KMS data key, for sessions whose
+// document requires encryption.
+func GenerateDataKey(ctx context.Context, t Target, keyID string, encryptionContext map[string]string) (plaintext, ciphertext []byte, err error) {
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := kms.NewFromConfig(cfg).GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             &keyID,
+		NumberOfBytes:     awssdk.Int32(64),
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate data key with %s: %w", keyID, err)
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
@@ -0,0 +1,184 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// StartBastion starts a stopped bastion and waits, with progress on stderr,
+// until it is running and its SSM agent is online. Polls every 5 seconds for
+// up to 10 minutes.
+func StartBastion(t Target, instanceID string) error {
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	client := ec2.NewFromConfig(cfg)
+	if _, err := client.StartInstances(ctx, &ec2.StartInstancesInput{
+		InstanceIds: []string{instanceID},
+	}); err != nil {
+		return fmt.Errorf("start instance %s: %w", instanceID, err)
+	}
+	log.Printf("Started bastion %s", instanceID)
+
+	const pollInterval = 5 * time.Second
+	const timeout = 10 * time.Minute
+	start := time.Now()
+	for time.Since(start) < timeout {
+		status, err := bastionStatus(ctx, cfg, client, instanceID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\r\033[K")
+			return err
+		}
+		if status == string(ssmtypes.PingStatusOnline) {
+			fmt.Fprintf(os.Stderr, "\r\033[K")
+			log.Printf("Bastion %s is online (took %s)", instanceID, time.Since(start).Truncate(time.Second))
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "\r\033[K⏳ Starting bastion %s: %s... %s",
+			instanceID, status, time.Since(start).Truncate(time.Second))
+		time.Sleep(pollInterval)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K")
+	return fmt.Errorf("bastion %s not online after %s", instanceID, timeout)
+}
+
+// bastionStatus returns the instance state until it is running, then the
+// SSM agent status ("waiting for SSM agent" until it registers).
+func bastionStatus(ctx context.Context, cfg awssdk.Config, client *ec2.Client, instanceID string) (string, error) {
+	out, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		return "", fmt.Errorf("describe instance %s: %w", instanceID, err)
+	}
+	state := ec2types.InstanceStateNamePending
+	for _, r := range out.Reservations {
+		for _, inst := range r.Instances {
+			if inst.State != nil {
+				state = inst.State.Name
+			}
+		}
+	}
+	switch state {
+	case ec2types.InstanceStateNameRunning:
+	case ec2types.InstanceStateNamePending, ec2types.InstanceStateNameStopped:
+		return string(state), nil
+	default:
+		return "", fmt.Errorf("bastion %s is %s", instanceID, state)
+	}
+
+	infos, err := agentInfo(ctx, cfg, []string{instanceID})
+	if err != nil {
+		return "", err
+	}
+	info, ok := infos[instanceID]
+	if !ok {
+		return "waiting for SSM agent", nil
+	}
+	if info.PingStatus != ssmtypes.PingStatusOnline {
+		return "SSM agent " + string(info.PingStatus), nil
+	}
+	return string(ssmtypes.PingStatusOnline), nil
+}
+
+// StopBastion stops a bastion started by StartBastion.
+func StopBastion(t Target, instanceID string) error {
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, t)
+	if err != nil {
+		return err
+	}
+	if _, err := ec2.NewFromConfig(cfg).StopInstances(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceID},
+	}); err != nil {
+		return fmt.Errorf("stop instance %s: %w", instanceID, err)
+	}
+	log.Printf("Stopped bastion %s", instanceID)
+	return nil
+}
+
+// StartedBastion records a bastion this tool started and should stop again
+// once no forward goes through it.
+type StartedBastion struct {
+	InstanceID string    `json:"instance_id"`
+	Target     Target    `json:"target"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// RecordStartedBastion adds b to the started-bastions file.
+func RecordStartedBastion(b StartedBastion) error {
+	started := StartedBastions()
+	for _, s := range started {
+		if s.InstanceID == b.InstanceID {
+			return nil
+		}
+	}
+	return writeStartedBastions(append(started, b))
+}
+
+// ForgetStartedBastion removes instanceID from the started-bastions file.
+func ForgetStartedBastion(instanceID string) error {
+	var keep []StartedBastion
+	for _, s := range StartedBastions() {
+		if s.InstanceID != instanceID {
+			keep = append(keep, s)
+		}
+	}
+	return writeStartedBastions(keep)
+}
+
+// StartedBastions returns the recorded bastions; a missing or unreadable
+// file means none.
+func StartedBastions() []StartedBastion {
+	data, err := os.ReadFile(startedBastionsPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: %v", err)
+		}
+		return nil
+	}
+	var started []StartedBastion
+	if err := json.Unmarshal(data, &started); err != nil {
+		log.Printf("Warning: parse %s: %v", startedBastionsPath(), err)
+		return nil
+	}
+	return started
+}
+
+func writeStartedBastions(started []StartedBastion) error {
+	path := startedBastionsPath()
+	if len(started) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(started, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func startedBastionsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".cache", "kube-ssm-proxy", "started-bastions.json")
+}
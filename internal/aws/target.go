@@ -0,0 +1,58 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Target identifies the credentials and region AWS calls are made with:
+// the shared-config Profile, optionally chained into Role.
+type Target struct {
+	Profile string
+	Region  string
+	Role    AssumeRole
+}
+
+// AssumeRole is a role assumed on top of a profile's credentials, e.g. to
+// reach a spoke account from a hub role. The zero value means no role.
+type AssumeRole struct {
+	ARN         string
+	ExternalID  string
+	SessionName string
+}
+
+// defaultSessionName is used when AssumeRole.SessionName is empty.
+const defaultSessionName = "kube-ssm-proxy"
+
+// loadConfig returns SDK config for t, wrapping the profile's credentials
+// in an STS AssumeRole provider when t.Role is set.
+func loadConfig(ctx context.Context, t Target) (awssdk.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithSharedConfigProfile(t.Profile),
+		config.WithRegion(t.Region),
+	)
+	if err != nil {
+		return awssdk.Config{}, fmt.Errorf("load aws config: %w", err)
+	}
+	if t.Role.ARN == "" {
+		return cfg, nil
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), t.Role.ARN,
+		func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = t.Role.SessionName
+			if o.RoleSessionName == "" {
+				o.RoleSessionName = defaultSessionName
+			}
+			if t.Role.ExternalID != "" {
+				o.ExternalID = &t.Role.ExternalID
+			}
+		})
+	cfg.Credentials = awssdk.NewCredentialsCache(provider)
+	return cfg, nil
+}
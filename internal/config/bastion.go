@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"kube-ssm-proxy/internal/partition"
+)
+
+// BastionSelector picks the bastion instance. In YAML it is either a string
+// (an instance ID such as "i-0abc..." or else the value of the instance's
+// Name tag) or a list of filters that must all match:
+//
+//	bastion:
+//	  - tag: "Purpose=bastion"
+//	  - vpc_id: "vpc-0123"
+type BastionSelector struct {
+	InstanceID string
+	Name       string
+	Filters    []BastionFilter
+}
+
+// BastionFilter is one entry of a bastion filter list. Exactly one field
+// is set.
+type BastionFilter struct {
+	Tag              string `yaml:"tag"` // key=value
+	VPCID            string `yaml:"vpc_id"`
+	SubnetID         string `yaml:"subnet_id"`
+	AvailabilityZone string `yaml:"availability_zone"`
+}
+
+// defaultBastionTag is used when neither bastion nor bastion_tag is set.
+const defaultBastionTag = "Purpose=bastion"
+
+var instanceIDPattern = regexp.MustCompile(`^i-[0-9a-f]{8}([0-9a-f]{9})?$`)
+
+// bastionSelectorType is decoded by UnmarshalYAML from a string or from a
+// list of bastionFilterType; the validator and schema generator need to
+// know that since they walk the struct types.
+var (
+	bastionSelectorType = reflect.TypeOf(BastionSelector{})
+	bastionFilterType   = reflect.TypeOf(BastionFilter{})
+)
+
+// IsZero reports whether no selector was configured.
+func (s BastionSelector) IsZero() bool {
+	return s.InstanceID == "" && s.Name == "" && len(s.Filters) == 0
+}
+
+func (s *BastionSelector) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if instanceIDPattern.MatchString(n.Value) {
+			*s = BastionSelector{InstanceID: n.Value}
+		} else {
+			*s = BastionSelector{Name: n.Value}
+		}
+		return nil
+	case yaml.SequenceNode:
+		var filters []BastionFilter
+		if err := n.Decode(&filters); err != nil {
+			return err
+		}
+		*s = BastionSelector{Filters: filters}
+		return nil
+	default:
+		return fmt.Errorf("line %d: bastion must be an instance ID, a Name tag or a list of filters", n.Line)
+	}
+}
+
+func (s BastionSelector) MarshalYAML() (any, error) {
+	switch {
+	case s.InstanceID != "":
+		return s.InstanceID, nil
+	case s.Name != "":
+		return s.Name, nil
+	case len(s.Filters) > 0:
+		return s.Filters, nil
+	default:
+		return nil, nil
+	}
+}
+
+// validateBastion checks the bastion_* settings, defaults the scope and
+// strategy, and normalises the first two into c.Bastion so callers only
+// have to look at one field. Settings ignored because use_bastion is false
+// are reported as warnings.
+func validateBastion(c *ClusterConfig, idx int, warns *warnings) error {
+	if !*c.UseBastion {
+		if c.BastionTag != "" {
+			warns.add(idx, "bastion_tag", "use_bastion is false but bastion_tag is set%s; bastion_tag will be ignored", c.origin("bastion_tag"))
+		}
+		if !c.Bastion.IsZero() {
+			warns.add(idx, "bastion", "use_bastion is false but bastion is set%s; bastion will be ignored", c.origin("bastion"))
+		}
+		return nil
+	}
+
+	if err := validateBastionLocation(c, idx, warns); err != nil {
+		return err
+	}
+
+	switch c.BastionScope {
+	case "":
+		// The cluster's VPC means nothing to a bastion elsewhere.
+		c.BastionScope = "vpc"
+		if c.RemoteBastion() {
+			c.BastionScope = "none"
+		}
+	case "vpc", "subnets":
+		if c.RemoteBastion() {
+			return errField(idx, "bastion_scope", "bastion_scope %s%s needs the bastion in the cluster's account and region; use none",
+				c.BastionScope, c.origin("bastion_scope"))
+		}
+	case "none":
+	default:
+		return errField(idx, "bastion_scope", "invalid bastion_scope %q%s (want vpc, subnets or none)", c.BastionScope, c.origin("bastion_scope"))
+	}
+
+	switch c.BastionStrategy {
+	case "":
+		c.BastionStrategy = "ssm-online"
+	case "ssm-online", "same-az", "newest", "random", "pick", "single":
+	default:
+		return errField(idx, "bastion_strategy", "invalid bastion_strategy %q%s (want ssm-online, same-az, newest, random, pick or single)",
+			c.BastionStrategy, c.origin("bastion_strategy"))
+	}
+
+	if c.BastionTag != "" && !c.Bastion.IsZero() {
+		// A cluster's own setting beats an inherited one of the other form.
+		_, tagInherited := c.Inherited["bastion_tag"]
+		_, selInherited := c.Inherited["bastion"]
+		switch {
+		case tagInherited && !selInherited:
+			c.BastionTag = ""
+		case selInherited && !tagInherited:
+			c.Bastion = BastionSelector{}
+		default:
+			return errField(idx, "bastion", "bastion and bastion_tag are mutually exclusive")
+		}
+	}
+	if c.BastionTag != "" {
+		if err := checkTag(c.BastionTag); err != nil {
+			return errField(idx, "bastion_tag", "invalid bastion_tag %q%s: %v", c.BastionTag, c.origin("bastion_tag"), err)
+		}
+		c.Bastion = BastionSelector{Filters: []BastionFilter{{Tag: c.BastionTag}}}
+		return nil
+	}
+	if c.Bastion.IsZero() {
+		c.Bastion = BastionSelector{Filters: []BastionFilter{{Tag: defaultBastionTag}}}
+		return nil
+	}
+
+	if c.Bastion.Name != "" && strings.HasPrefix(c.Bastion.Name, "i-") {
+		return errField(idx, "bastion", "bastion %q%s looks like an instance ID but is not one (want i- followed by 8 or 17 hex digits)",
+			c.Bastion.Name, c.origin("bastion"))
+	}
+	if c.Bastion.InstanceID != "" || c.Bastion.Name != "" {
+		return nil
+	}
+	for i, f := range c.Bastion.Filters {
+		if err := checkBastionFilter(f, c.EffectiveBastionRegion()); err != nil {
+			return errField(idx, "bastion", "bastion filter %d%s: %v", i, c.origin("bastion"), err)
+		}
+	}
+	return nil
+}
+
+// validateBastionLocation checks bastion_profile, bastion_region and
+// bastion_account.
+func validateBastionLocation(c *ClusterConfig, idx int, warns *warnings) error {
+	if c.BastionRegion != "" {
+		if err := checkRegion(c.BastionRegion, idx, "bastion_region", c.origin("bastion_region"), warns); err != nil {
+			return err
+		}
+		if p, q := partition.ForRegion(c.BastionRegion), partition.ForRegion(c.Region); p != q {
+			return errField(idx, "bastion_region", "bastion_region %s is in partition %s but region %s is in %s",
+				c.BastionRegion, p, c.Region, q)
+		}
+	}
+	if c.BastionAccount != "" && !isAccountID(c.BastionAccount) {
+		return errField(idx, "bastion_account", "invalid bastion_account %q%s: expected a 12-digit account ID",
+			c.BastionAccount, c.origin("bastion_account"))
+	}
+	return nil
+}
+
+// EffectiveBastionRegion returns the region the bastion is looked up in:
+// bastion_region if set, else the cluster's region.
+func (c *ClusterConfig) EffectiveBastionRegion() string {
+	if c.BastionRegion != "" {
+		return c.BastionRegion
+	}
+	return c.Region
+}
+
+// RemoteBastion reports whether the bastion is configured to live outside
+// the cluster's account or region. An account is only known to match when
+// it equals that of assume_role_arn.
+func (c *ClusterConfig) RemoteBastion() bool {
+	return (c.BastionProfile != "" && c.BastionProfile != c.Profile) ||
+		(c.BastionRegion != "" && c.BastionRegion != c.Region) ||
+		(c.BastionAccount != "" && c.BastionAccount != partition.AccountFromARN(c.AssumeRoleARN))
+}
+
+func isAccountID(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func checkBastionFilter(f BastionFilter, region string) error {
+	set := 0
+	for _, v := range []string{f.Tag, f.VPCID, f.SubnetID, f.AvailabilityZone} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of tag, vpc_id, subnet_id or availability_zone is required")
+	}
+	switch {
+	case f.Tag != "":
+		if err := checkTag(f.Tag); err != nil {
+			return fmt.Errorf("tag %q: %v", f.Tag, err)
+		}
+	case f.VPCID != "" && !strings.HasPrefix(f.VPCID, "vpc-"):
+		return fmt.Errorf("invalid vpc_id %q", f.VPCID)
+	case f.SubnetID != "" && !strings.HasPrefix(f.SubnetID, "subnet-"):
+		return fmt.Errorf("invalid subnet_id %q", f.SubnetID)
+	case f.AvailabilityZone != "" && !strings.HasPrefix(f.AvailabilityZone, region):
+		return fmt.Errorf("availability_zone %q is not in region %s", f.AvailabilityZone, region)
+	}
+	return nil
+}
+
+func checkTag(tag string) error {
+	key, _, ok := strings.Cut(tag, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value format")
+	}
+	return nil
+}
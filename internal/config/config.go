@@ -1,11 +1,18 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
 
 	"gopkg.in/yaml.v3"
+
+	"kube-ssm-proxy/internal/partition"
 )
 
 // ClusterConfig holds configuration for a single cluster.
@@ -16,9 +23,116 @@ type ClusterConfig struct {
 	Environment string `yaml:"environment"`
 	Profile     string `yaml:"profile"`
 	UseBastion  *bool  `yaml:"use_bastion"`
-	BastionTag  string `yaml:"bastion_tag"`
+	BastionTag  string `yaml:"bastion_tag"` // older single-tag form of Bastion
+
+	// Bastion selects the bastion instance. Validation normalises bastion_tag
+	// (or the Purpose=bastion default) into it.
+	Bastion BastionSelector `yaml:"bastion"`
+	// BastionScope restricts the bastion search to the cluster's VPC ("vpc",
+	// the default), to its control-plane subnets ("subnets"), or not at all
+	// ("none", e.g. for a bastion in a peered VPC).
+	BastionScope string `yaml:"bastion_scope"`
+	// BastionStrategy chooses among several matching bastions: "ssm-online"
+	// (default), "same-az", "newest", "random", "pick" or "single".
+	BastionStrategy string `yaml:"bastion_strategy"`
+	// AutoStartBastion starts a stopped bastion without asking;
+	// AutoStopBastion stops a bastion this tool started once the last
+	// forward through it is killed.
+	AutoStartBastion bool `yaml:"auto_start_bastion,omitempty"`
+	AutoStopBastion  bool `yaml:"auto_stop_bastion,omitempty"`
+
+	// BastionProfile, BastionRegion and BastionAccount place the bastion in
+	// another account or region (e.g. a shared-services hub reaching the
+	// cluster's VPC over Transit Gateway). They apply to the EC2 lookup and
+	// the SSM session; kubeconfig credentials keep using Profile.
+	BastionProfile string `yaml:"bastion_profile"`
+	BastionRegion  string `yaml:"bastion_region"`
+	BastionAccount string `yaml:"bastion_account"`
+
+	// SSMDocument replaces AWS-StartPortForwardingSessionToRemoteHost for the
+	// session, e.g. a company document enforcing KMS encryption and logging.
+	// It must accept the same host/portNumber/localPortNumber parameters;
+	// SSMDocumentParameters are passed in addition.
+	SSMDocument           string            `yaml:"ssm_document"`
+	SSMDocumentVersion    string            `yaml:"ssm_document_version"`
+	SSMDocumentParameters map[string]string `yaml:"ssm_document_parameters"`
+
+	// Aliases are alternative names accepted by headless selection and
+	// searchable in the selector. Tags are free-form search labels.
+	Aliases []string `yaml:"aliases"`
+	Tags    []string `yaml:"tags"`
+
+	LocalPort int `yaml:"local_port,omitempty"` // pinned API tunnel port; derived from name if unset
+
+	// AssumeRoleARN is a role assumed on top of Profile for every AWS call,
+	// the SSM session and kubectl tokens, for clusters in accounts reached by
+	// chaining from a hub role.
+	AssumeRoleARN   string `yaml:"assume_role_arn"`
+	ExternalID      string `yaml:"external_id"`
+	RoleSessionName string `yaml:"role_session_name"`
+
+	// CredentialProvider selects the kubeconfig exec plugin. Falls back to
+	// the top-level credential_provider, then Granted.
+	CredentialProvider CredentialProvider `yaml:"credential_provider,omitempty"`
+
+	// Forwards are extra tunnels opened through the bastion alongside the
+	// Kubernetes API tunnel.
+	Forwards []ForwardConfig `yaml:"forwards"`
+
+	// Comment is written above the entry by MarshalClusters and
+	// MergeClusters, e.g. to explain a generated setting. It is not read.
+	Comment string `yaml:"-"`
+
+	// Sources maps each YAML field name to the file its final value was
+	// read from. Populated by Load; useful when includes override fields.
+	Sources map[string]string `yaml:"-"`
+	// Inherited maps fields taken from defaults or environments to their
+	// origin ("defaults" or "environments.<name>").
+	Inherited map[string]string `yaml:"-"`
+}
+
+// ForwardConfig describes an additional port forward through the cluster's
+// bastion, e.g. to a database. Exactly one of Host and Resource is set.
+type ForwardConfig struct {
+	Name       string `yaml:"name"`
+	Host       string `yaml:"host"`        // remote hostname or IP
+	Resource   string `yaml:"resource"`    // AWS resource reference, e.g. "rds:orders-db"
+	RemotePort int    `yaml:"remote_port"` // defaults to the resource's port
+	LocalPort  int    `yaml:"local_port"`  // allocated if unset
+}
+
+// CredentialProvider configures how kubectl obtains EKS tokens. Type is one
+// of "granted" (default), "aws-cli", "aws-vault" or "custom"; Command and Args
+// apply to custom only. Args and Env values are Go templates over .Name,
+// .ClusterName, .Region, .Profile, .AccountID, .RoleARN, .ExternalID and
+// .SessionName.
+type CredentialProvider struct {
+	Type    string            `yaml:"type"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+}
+
+// IsZero reports whether no credential provider was configured.
+func (p CredentialProvider) IsZero() bool {
+	return p.Type == "" && p.Command == "" && len(p.Args) == 0 && len(p.Env) == 0
+}
+
+// credentialTemplateData holds every key credential templates may reference.
+var credentialTemplateData = map[string]string{
+	"Name": "", "ClusterName": "", "Region": "", "Profile": "", "AccountID": "",
+	"RoleARN": "", "ExternalID": "", "SessionName": "",
+}
+
+// PortRange bounds local port allocation for tunnels.
+type PortRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
 }
 
+// resourceKinds are the accepted prefixes of ForwardConfig.Resource.
+var resourceKinds = map[string]bool{"rds": true, "rds-cluster": true, "elasticache": true}
+
 // SSOConfig holds SSO settings used for login hints.
 type SSOConfig struct {
 	StartURL string `yaml:"sso_start_url"`
@@ -27,49 +141,105 @@ type SSOConfig struct {
 
 // Config holds all top-level configuration.
 type Config struct {
+	Path  string   // file the configuration was loaded from
+	Files []string // every file merged, in order (includes first, Path last)
+
+	// Interpolated lists every value whose ${VAR} references were expanded.
+	Interpolated []Interpolation
+	// Outdated lists files in an older layout than CurrentVersion (see
+	// migrate); they were migrated in memory and can be updated with
+	// `config migrate`.
+	Outdated []string
+	// Warnings are validation findings that did not stop loading, prefixed
+	// with file:line:column.
+	Warnings  []string
 	SSO       SSOConfig
 	Clusters  []ClusterConfig
 	FzfHeight string
+	PortRange PortRange
 }
 
 type configFile struct {
+	Version   int             `yaml:"version"`
+	Include   []string        `yaml:"include"`
 	SSO       SSOConfig       `yaml:"sso"`
 	Clusters  []ClusterConfig `yaml:"clusters"`
 	FzfHeight string          `yaml:"fzf_height"`
+	PortRange PortRange       `yaml:"port_range"`
+
+	CredentialProvider CredentialProvider `yaml:"credential_provider"`
+
+	// Defaults and Environments are applied to clusters at the node level
+	// by applyInheritance; they are decoded here only for type checking.
+	Defaults     ClusterConfig            `yaml:"defaults"`
+	Environments map[string]ClusterConfig `yaml:"environments"`
 }
 
-// Load reads clusters.yaml from the same directory as the running binary
-// and returns validated configuration.
-func Load() (Config, error) {
-	path, err := findConfigPath()
+// Load locates the config file (see findConfigPath for the search order) and
+// returns validated configuration. explicitPath, if non-empty, is used as-is.
+func Load(explicitPath string) (Config, error) {
+	path, err := findConfigPath(explicitPath)
 	if err != nil {
 		return Config{}, err
 	}
 
-	data, err := os.ReadFile(path)
+	doc, err := parseDocument(path)
 	if err != nil {
-		return Config{}, fmt.Errorf("read config: %w", err)
+		return Config{}, err
 	}
 
+	v := newValidator(doc)
 	var cf configFile
-	if err := yaml.Unmarshal(data, &cf); err != nil {
-		return Config{}, fmt.Errorf("parse config: %w", err)
+	if err := doc.root.Decode(&cf); err != nil {
+		problems := v.decodeProblems(doc.root, path, err)
+		msgs := make([]string, len(problems))
+		for i, p := range problems {
+			msgs[i] = p.String()
+		}
+		return Config{}, fmt.Errorf("parse config: %s", strings.Join(msgs, "; "))
 	}
+	doc.annotate(cf.Clusters)
 
 	if len(cf.Clusters) == 0 {
 		return Config{}, fmt.Errorf("no clusters defined in %s", path)
 	}
+	clusterNodes := mappingValue(doc.root, "clusters").Content
 
+	applyGlobalCredentials(cf.Clusters, cf.CredentialProvider)
+
+	// fieldErr positions a *fieldError at the field's node.
+	fieldErr := func(err error) error {
+		var fe *fieldError
+		if !errors.As(err, &fe) {
+			return err
+		}
+		return v.errorAt(v.fieldNode(clusterNodes[fe.Index], fe.Field), err)
+	}
+
+	var warns warnings
 	seen := make(map[string]bool)
+	pinned := make(map[int]string)
 	for i := range cf.Clusters {
 		c := &cf.Clusters[i]
-		if err := validateCluster(c, i); err != nil {
-			return Config{}, err
+		if err := validateCluster(c, i, &warns); err != nil {
+			return Config{}, fieldErr(err)
 		}
 		if seen[c.Name] {
-			return Config{}, fmt.Errorf("cluster %d: duplicate name %q", i, c.Name)
+			return Config{}, fieldErr(errField(i, "name", "duplicate name %q", c.Name))
 		}
 		seen[c.Name] = true
+		if err := checkPinnedPorts(c, i, pinned); err != nil {
+			return Config{}, fieldErr(err)
+		}
+	}
+	if err := checkAliases(cf.Clusters); err != nil {
+		return Config{}, fieldErr(err)
+	}
+	warningMsgs := make([]string, len(warns))
+	for i, w := range warns {
+		p := v.problem(v.fieldNode(clusterNodes[w.Index], w.Field), w.Error())
+		p.Warning = true
+		warningMsgs[i] = p.String()
 	}
 
 	fzfHeight := cf.FzfHeight
@@ -77,25 +247,144 @@ func Load() (Config, error) {
 		fzfHeight = "40%"
 	}
 
+	portRange, err := resolvePortRange(cf.PortRange)
+	if err != nil {
+		return Config{}, v.errorAt(mappingValue(doc.root, "port_range"), err)
+	}
+
 	return Config{
-		SSO:       cf.SSO,
-		Clusters:  cf.Clusters,
-		FzfHeight: fzfHeight,
+		Path:  path,
+		Files: doc.files(),
+
+		Interpolated: doc.interpolated(),
+		Outdated:     doc.outdated(),
+		Warnings:     warningMsgs,
+		SSO:          cf.SSO,
+		Clusters:     cf.Clusters,
+		FzfHeight:    fzfHeight,
+		PortRange:    portRange,
 	}, nil
 }
 
-func validateCluster(c *ClusterConfig, idx int) error {
+// document is the merged config tree after includes and inheritance.
+type document struct {
+	path      string
+	layers    []layer
+	root      *yaml.Node
+	sources   []map[string]string
+	inherited []map[string]string
+}
+
+// parseDocument loads path with its includes, merges them and applies
+// defaults/environments inheritance, without decoding or validating.
+func parseDocument(path string) (*document, error) {
+	layers, err := loadLayers(path, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	root, sources, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	inherited, err := applyInheritance(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &document{path: path, layers: layers, root: root, sources: sources, inherited: inherited}, nil
+}
+
+// annotate attaches per-field provenance to decoded clusters.
+func (d *document) annotate(clusters []ClusterConfig) {
+	for i := range clusters {
+		clusters[i].Sources = d.sources[i]
+		clusters[i].Inherited = d.inherited[i]
+	}
+}
+
+// interpolated collects the ${VAR} expansions of every layer.
+func (d *document) interpolated() []Interpolation {
+	var out []Interpolation
+	for _, l := range d.layers {
+		out = append(out, l.interpolated...)
+	}
+	return out
+}
+
+// outdated lists files in a layout older than CurrentVersion.
+func (d *document) outdated() []string {
+	var out []string
+	for _, l := range d.layers {
+		if l.version < CurrentVersion {
+			out = append(out, l.path)
+		}
+	}
+	return out
+}
+
+// files lists every merged file in merge order.
+func (d *document) files() []string {
+	files := make([]string, len(d.layers))
+	for i, l := range d.layers {
+		files[i] = l.path
+	}
+	return files
+}
+
+// fieldError is a validation failure tied to one field of one cluster.
+type fieldError struct {
+	Index int
+	Field string // YAML key the error refers to
+	Msg   string
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("cluster %d: %s", e.Index, e.Msg)
+}
+
+func errField(idx int, field, format string, args ...any) error {
+	return &fieldError{Index: idx, Field: field, Msg: fmt.Sprintf(format, args...)}
+}
+
+// checkRegion rejects a region missing from the partition's region table,
+// unless it is named like a known region (presumably one launched since),
+// which only warns.
+func checkRegion(region string, idx int, field, origin string, warns *warnings) error {
+	if partition.KnownRegion(region) {
+		return nil
+	}
+	p := partition.ForRegion(region)
+	if !partition.RegionLike(region) {
+		return errField(idx, field, "invalid %s %q%s: not a region of partition %s", field, region, origin, p)
+	}
+	warns.add(idx, field, "%s %q%s is not a known region of partition %s; check for typos", field, region, origin, p)
+	return nil
+}
+
+// warnings collects findings that do not make a cluster invalid.
+type warnings []*fieldError
+
+func (w *warnings) add(idx int, field, format string, args ...any) {
+	*w = append(*w, &fieldError{Index: idx, Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// validateCluster checks the effective (post-inheritance) values of c and
+// fills in defaults. Errors about inherited values name their origin;
+// non-fatal findings are appended to warns.
+func validateCluster(c *ClusterConfig, idx int, warns *warnings) error {
 	if c.Name == "" {
-		return fmt.Errorf("cluster %d: missing name", idx)
+		return errField(idx, "name", "missing name")
 	}
-	if c.Region == "" || len(c.Region) < 3 {
-		return fmt.Errorf("cluster %d: invalid region %q", idx, c.Region)
+	if c.Region == "" {
+		return errField(idx, "region", "missing region")
+	}
+	if err := checkRegion(c.Region, idx, "region", c.origin("region"), warns); err != nil {
+		return err
 	}
 	if c.ClusterName == "" {
-		return fmt.Errorf("cluster %d: missing cluster_name", idx)
+		return errField(idx, "cluster_name", "missing cluster_name")
 	}
 	if c.Profile == "" {
-		return fmt.Errorf("cluster %d: missing profile", idx)
+		return errField(idx, "profile", "missing profile")
 	}
 	if c.Environment == "" {
 		c.Environment = "unknown"
@@ -104,27 +393,239 @@ func validateCluster(c *ClusterConfig, idx int) error {
 		t := true
 		c.UseBastion = &t
 	}
-	if !*c.UseBastion && c.BastionTag != "" {
-		fmt.Printf("warning: cluster %q has use_bastion: false but bastion_tag is set — bastion_tag will be ignored\n", c.Name)
+	if err := validateBastion(c, idx, warns); err != nil {
+		return err
+	}
+	if c.LocalPort < 0 || c.LocalPort > 65535 {
+		return errField(idx, "local_port", "invalid local_port %d", c.LocalPort)
+	}
+	if err := validateCredentials(c.CredentialProvider); err != nil {
+		return errField(idx, "credential_provider", "credential_provider: %v%s", err, c.origin("credential_provider"))
+	}
+	if err := validateRole(c, idx); err != nil {
+		return err
+	}
+	if err := validateDocument(c, idx); err != nil {
+		return err
+	}
+	return validateForwards(c, idx)
+}
+
+func validateRole(c *ClusterConfig, idx int) error {
+	if c.AssumeRoleARN == "" {
+		if c.ExternalID != "" || c.RoleSessionName != "" {
+			return errField(idx, "assume_role_arn", "external_id and role_session_name require assume_role_arn")
+		}
+		return nil
+	}
+	parts := strings.SplitN(c.AssumeRoleARN, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" ||
+		len(parts[4]) != 12 || !strings.HasPrefix(parts[5], "role/") {
+		return errField(idx, "assume_role_arn", "invalid assume_role_arn %q%s: expected arn:PARTITION:iam::ACCOUNT:role/NAME",
+			c.AssumeRoleARN, c.origin("assume_role_arn"))
+	}
+	if p := partition.ForRegion(c.Region); parts[1] != p {
+		return errField(idx, "assume_role_arn", "assume_role_arn %q is in partition %s but region %s is in %s",
+			c.AssumeRoleARN, parts[1], c.Region, p)
+	}
+	if c.ExternalID != "" && c.CredentialProvider.Type != "custom" {
+		return errField(idx, "external_id", "external_id needs credential_provider type custom: aws eks get-token cannot pass it")
+	}
+	return nil
+}
+
+// documentPattern matches SSM document names and ARNs.
+var documentPattern = regexp.MustCompile(`^([a-zA-Z0-9_.-]{3,128}|arn:[a-z-]+:ssm:[a-z0-9-]*:[0-9]*:document/[a-zA-Z0-9_.-]{3,128})$`)
+
+// reservedDocumentParameters are set by the tool for every session.
+var reservedDocumentParameters = map[string]bool{"host": true, "portNumber": true, "localPortNumber": true}
+
+func validateDocument(c *ClusterConfig, idx int) error {
+	if c.SSMDocument == "" {
+		if c.SSMDocumentVersion != "" || len(c.SSMDocumentParameters) > 0 {
+			return errField(idx, "ssm_document", "ssm_document_version and ssm_document_parameters require ssm_document")
+		}
+		return nil
+	}
+	if !documentPattern.MatchString(c.SSMDocument) {
+		return errField(idx, "ssm_document", "invalid ssm_document %q%s", c.SSMDocument, c.origin("ssm_document"))
+	}
+	if v := c.SSMDocumentVersion; v != "" && v != "$DEFAULT" && v != "$LATEST" {
+		if _, err := strconv.Atoi(v); err != nil {
+			return errField(idx, "ssm_document_version", "invalid ssm_document_version %q%s (want a number, $DEFAULT or $LATEST)",
+				v, c.origin("ssm_document_version"))
+		}
+	}
+	for k, v := range c.SSMDocumentParameters {
+		switch {
+		case k == "":
+			return errField(idx, "ssm_document_parameters", "empty ssm_document_parameters key")
+		case reservedDocumentParameters[k]:
+			return errField(idx, "ssm_document_parameters", "ssm_document_parameters: %s is set by kube-ssm-proxy", k)
+		case strings.ContainsAny(k+v, ",= \t"):
+			return errField(idx, "ssm_document_parameters", "ssm_document_parameters: %s=%q must not contain commas, '=' or whitespace", k, v)
+		}
+	}
+	return nil
+}
+
+// applyGlobalCredentials merges the top-level credential provider into each
+// cluster's. The cluster's type wins; the global command and args are only
+// used when the cluster has the same (or no) type and sets none of its own.
+// Env is combined, with the cluster's keys winning.
+func applyGlobalCredentials(clusters []ClusterConfig, global CredentialProvider) {
+	for i := range clusters {
+		p := &clusters[i].CredentialProvider
+		if p.Type == "" {
+			p.Type = global.Type
+		}
+		if p.Type == global.Type && p.Command == "" && len(p.Args) == 0 {
+			p.Command, p.Args = global.Command, global.Args
+		}
+		if len(global.Env) > 0 {
+			env := make(map[string]string, len(global.Env)+len(p.Env))
+			maps.Copy(env, global.Env)
+			maps.Copy(env, p.Env)
+			p.Env = env
+		}
+	}
+}
+
+func validateCredentials(p CredentialProvider) error {
+	switch p.Type {
+	case "", "granted", "aws-cli", "aws-vault":
+		if p.Command != "" || len(p.Args) > 0 {
+			return fmt.Errorf("command and args are only allowed with type custom")
+		}
+	case "custom":
+		if p.Command == "" {
+			return fmt.Errorf("type custom requires command")
+		}
+	default:
+		return fmt.Errorf("unknown type %q (want granted, aws-cli, aws-vault or custom)", p.Type)
+	}
+
+	templates := append([]string(nil), p.Args...)
+	for _, v := range p.Env {
+		templates = append(templates, v)
+	}
+	for _, text := range templates {
+		t, err := template.New("arg").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return fmt.Errorf("template %q: %w", text, err)
+		}
+		if err := t.Execute(io.Discard, credentialTemplateData); err != nil {
+			return fmt.Errorf("template %q: %w", text, err)
+		}
+	}
+	return nil
+}
+
+// resolvePortRange applies the default range (49152-65535) and checks that
+// the range is sane.
+func resolvePortRange(r PortRange) (PortRange, error) {
+	if r.Min == 0 {
+		r.Min = 49152
+	}
+	if r.Max == 0 {
+		r.Max = 65535
+	}
+	if r.Min < 1024 || r.Max > 65535 || r.Min > r.Max {
+		return PortRange{}, fmt.Errorf("invalid port_range %d-%d: must be within 1024-65535 with min <= max", r.Min, r.Max)
+	}
+	return r, nil
+}
+
+// checkAliases rejects aliases that are empty, reserved, or collide with
+// any cluster's name or another alias.
+func checkAliases(clusters []ClusterConfig) error {
+	owners := make(map[string]string)
+	for _, c := range clusters {
+		owners[c.Name] = c.Name
+	}
+	for i, c := range clusters {
+		for _, a := range c.Aliases {
+			switch prev, ok := owners[a]; {
+			case a == "":
+				return errField(i, "aliases", "empty alias")
+			case a == "kill_all":
+				return errField(i, "aliases", "alias %q is reserved", a)
+			case ok && prev == c.Name:
+				return errField(i, "aliases", "alias %q duplicates the cluster's own name or another of its aliases", a)
+			case ok:
+				return errField(i, "aliases", "alias %q collides with cluster %q", a, prev)
+			}
+			owners[a] = c.Name
+		}
 	}
-	if *c.UseBastion && c.BastionTag == "" {
-		c.BastionTag = "Purpose=bastion"
+	return nil
+}
+
+// checkPinnedPorts rejects a local_port already pinned by an earlier
+// cluster or forward. owners maps pinned ports to their owner's name.
+func checkPinnedPorts(c *ClusterConfig, idx int, owners map[int]string) error {
+	claim := func(port int, field, owner string) error {
+		if port == 0 {
+			return nil
+		}
+		if prev, ok := owners[port]; ok {
+			return errField(idx, field, "local_port %d of %s is already used by %s", port, owner, prev)
+		}
+		owners[port] = owner
+		return nil
+	}
+	if err := claim(c.LocalPort, "local_port", c.Name); err != nil {
+		return err
+	}
+	for _, f := range c.Forwards {
+		if err := claim(f.LocalPort, "forwards", c.Name+"/"+f.Name); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func findConfigPath() (string, error) {
-	// First try next to the binary
-	exe, err := os.Executable()
-	if err == nil {
-		p := filepath.Join(filepath.Dir(exe), "clusters.yaml")
-		if _, err := os.Stat(p); err == nil {
-			return p, nil
+func validateForwards(c *ClusterConfig, idx int) error {
+	if len(c.Forwards) > 0 && !*c.UseBastion {
+		return errField(idx, "forwards", "forwards require use_bastion: true")
+	}
+	names := make(map[string]bool)
+	for i, f := range c.Forwards {
+		if f.Name == "" {
+			return errField(idx, "forwards", "forward %d: missing name", i)
+		}
+		if names[f.Name] {
+			return errField(idx, "forwards", "forward %d: duplicate name %q", i, f.Name)
+		}
+		names[f.Name] = true
+
+		switch {
+		case f.Host == "" && f.Resource == "":
+			return errField(idx, "forwards", "forward %q: one of host or resource is required", f.Name)
+		case f.Host != "" && f.Resource != "":
+			return errField(idx, "forwards", "forward %q: host and resource are mutually exclusive", f.Name)
+		case f.Resource != "":
+			kind, id, ok := strings.Cut(f.Resource, ":")
+			if !ok || id == "" || !resourceKinds[kind] {
+				return errField(idx, "forwards", "forward %q: invalid resource %q (want rds:ID, rds-cluster:ID or elasticache:ID)", f.Name, f.Resource)
+			}
+		case f.RemotePort == 0:
+			return errField(idx, "forwards", "forward %q: remote_port is required with host", f.Name)
+		}
+		if f.RemotePort < 0 || f.RemotePort > 65535 {
+			return errField(idx, "forwards", "forward %q: invalid remote_port %d", f.Name, f.RemotePort)
+		}
+		if f.LocalPort < 0 || f.LocalPort > 65535 {
+			return errField(idx, "forwards", "forward %q: invalid local_port %d", f.Name, f.LocalPort)
 		}
 	}
-	// Fall back to current working directory
-	if _, err := os.Stat("clusters.yaml"); err == nil {
-		return "clusters.yaml", nil
+	return nil
+}
+
+// origin returns " (from <origin>)" when field was inherited, else "".
+func (c *ClusterConfig) origin(field string) string {
+	if o, ok := c.Inherited[field]; ok {
+		return fmt.Sprintf(" (from %s)", o)
 	}
-	return "", fmt.Errorf("clusters.yaml not found (checked binary dir and cwd)")
+	return ""
 }
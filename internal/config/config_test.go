@@ -0,0 +1,36 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyGlobalCredentials(t *testing.T) {
+	global := CredentialProvider{
+		Type:    "custom",
+		Command: "my-helper",
+		Args:    []string{"{{.Profile}}"},
+		Env:     map[string]string{"HELPER_REGION": "{{.Region}}", "HELPER_MODE": "global"},
+	}
+	clusters := []ClusterConfig{
+		{Name: "plain"},
+		{Name: "env-only", CredentialProvider: CredentialProvider{Env: map[string]string{"HELPER_MODE": "cluster"}}},
+		{Name: "own-type", CredentialProvider: CredentialProvider{Type: "aws-cli"}},
+	}
+	applyGlobalCredentials(clusters, global)
+
+	want := []CredentialProvider{
+		global,
+		{Type: "custom", Command: "my-helper", Args: []string{"{{.Profile}}"},
+			Env: map[string]string{"HELPER_REGION": "{{.Region}}", "HELPER_MODE": "cluster"}},
+		{Type: "aws-cli", Env: global.Env},
+	}
+	for i, c := range clusters {
+		if !reflect.DeepEqual(c.CredentialProvider, want[i]) {
+			t.Errorf("%s: got %+v, want %+v", c.Name, c.CredentialProvider, want[i])
+		}
+		if err := validateCredentials(c.CredentialProvider); err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// layer is one parsed config file taking part in a merge.
+type layer struct {
+	path         string
+	root         *yaml.Node // top-level mapping node
+	version      int        // layout version of the file, before migration
+	interpolated []Interpolation
+}
+
+// loadLayers reads path and every file it includes, returning the documents
+// in merge order: each file's includes (recursively, in listed order) come
+// before the file itself, so the including file overrides what it includes.
+// A file reached twice is only applied the first time. Each file is migrated
+// to CurrentVersion in memory, then its ${VAR} references are expanded,
+// before includes are resolved (so include paths may use them too).
+func loadLayers(path string, seen map[string]bool) ([]layer, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", path, err)
+	}
+	if seen[abs] {
+		return nil, nil
+	}
+	seen[abs] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parse %s: top level must be a mapping", path)
+	}
+	version, _, err := migrate(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	interpolated, err := interpolate(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var includes []string
+	if n := mappingValue(root, "include"); n != nil {
+		if err := n.Decode(&includes); err != nil {
+			return nil, fmt.Errorf("%s: include must be a list of paths: %w", path, err)
+		}
+	}
+
+	var layers []layer
+	for _, pattern := range includes {
+		files, err := expandInclude(filepath.Dir(path), pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, f := range files {
+			sub, err := loadLayers(f, seen)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, sub...)
+		}
+	}
+	return append(layers, layer{path: path, root: root, version: version, interpolated: interpolated}), nil
+}
+
+// expandInclude resolves an include entry relative to baseDir. A leading
+// "~/" expands to the home directory. Glob patterns may match nothing;
+// plain paths must exist.
+func expandInclude(baseDir, pattern string) ([]string, error) {
+	if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %w", pattern, err)
+		}
+		pattern = filepath.Join(home, rest)
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(baseDir, pattern)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		if _, err := os.Stat(pattern); err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("include %q: %w", pattern, err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// mergeLayers folds layers into a single mapping node. Top-level mappings
+// are merged key by key; clusters are matched by name so a later file can
+// override individual fields of an earlier cluster or add new ones. The
+// returned sources slice parallels the merged cluster list and records, for
+// each cluster, the file every field's final value came from.
+func mergeLayers(layers []layer) (*yaml.Node, []map[string]string, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode}
+	clusters := &yaml.Node{Kind: yaml.SequenceNode}
+	var sources []map[string]string
+	byName := make(map[string]int)
+
+	for _, l := range layers {
+		for i := 0; i+1 < len(l.root.Content); i += 2 {
+			key, val := l.root.Content[i], l.root.Content[i+1]
+			switch key.Value {
+			case "include", "version":
+				continue
+			case "clusters":
+				if val.Kind != yaml.SequenceNode {
+					return nil, nil, fmt.Errorf("%s:%d: clusters must be a list", l.path, val.Line)
+				}
+				inLayer := make(map[string]bool)
+				for _, item := range val.Content {
+					if item.Kind != yaml.MappingNode {
+						return nil, nil, fmt.Errorf("%s:%d: cluster entry must be a mapping", l.path, item.Line)
+					}
+					name := ""
+					if n := mappingValue(item, "name"); n != nil {
+						name = n.Value
+					}
+					if name != "" && inLayer[name] {
+						return nil, nil, fmt.Errorf("%s:%d: duplicate cluster name %q", l.path, item.Line, name)
+					}
+					inLayer[name] = true
+					idx, ok := byName[name]
+					if !ok || name == "" {
+						idx = len(clusters.Content)
+						clusters.Content = append(clusters.Content, &yaml.Node{Kind: yaml.MappingNode})
+						sources = append(sources, make(map[string]string))
+						if name != "" {
+							byName[name] = idx
+						}
+					}
+					dst := clusters.Content[idx]
+					for j := 0; j+1 < len(item.Content); j += 2 {
+						field := item.Content[j].Value
+						setMappingValue(dst, item.Content[j], item.Content[j+1])
+						// An overlay repeats the name only as a key; keep the original source.
+						if field == "name" && ok {
+							continue
+						}
+						sources[idx][field] = l.path
+					}
+				}
+			default:
+				mergeValue(merged, key, val)
+			}
+		}
+	}
+
+	if len(clusters.Content) > 0 {
+		setMappingValue(merged, &yaml.Node{Kind: yaml.ScalarNode, Value: "clusters"}, clusters)
+	}
+	return merged, sources, nil
+}
+
+// mergeValue sets key in dst, merging recursively when both the existing
+// and new values are mappings.
+func mergeValue(dst, key, val *yaml.Node) {
+	existing := mappingValue(dst, key.Value)
+	if existing == nil || existing.Kind != yaml.MappingNode || val.Kind != yaml.MappingNode {
+		setMappingValue(dst, key, copyMapping(val))
+		return
+	}
+	for i := 0; i+1 < len(val.Content); i += 2 {
+		mergeValue(existing, val.Content[i], val.Content[i+1])
+	}
+}
+
+// copyMapping returns a copy of n whose mapping nodes can be merged into
+// without modifying the layer n was read from. Other nodes are shared.
+func copyMapping(n *yaml.Node) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return n
+	}
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		if i%2 == 1 {
+			child = copyMapping(child)
+		}
+		c.Content[i] = child
+	}
+	return &c
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces or appends key in a mapping node.
+func setMappingValue(m, key, val *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key.Value {
+			m.Content[i+1] = val
+			return
+		}
+	}
+	m.Content = append(m.Content, key, val)
+}
+
+// SourceSummary describes which file each of the cluster's fields came from,
+// e.g. "shared.yaml (name, region); local.yaml (profile)".
+func (c ClusterConfig) SourceSummary() string {
+	byFile := make(map[string][]string)
+	var order []string
+	for field, file := range c.Sources {
+		if _, ok := byFile[file]; !ok {
+			order = append(order, file)
+		}
+		byFile[file] = append(byFile[file], field)
+	}
+	sort.Strings(order)
+
+	parts := make([]string, 0, len(order))
+	for _, file := range order {
+		fields := byFile[file]
+		sort.Strings(fields)
+		parts = append(parts, fmt.Sprintf("%s (%s)", file, strings.Join(fields, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseLayer parses src as the top-level mapping of a layer read from path.
+func parseLayer(t *testing.T, path, src string) layer {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return layer{path: path, root: doc.Content[0]}
+}
+
+func TestMergeLayersLeavesEarlierLayerUnchanged(t *testing.T) {
+	base := parseLayer(t, "base.yaml", "defaults:\n  bastion:\n    name: shared\n    strategy: newest\n")
+	local := parseLayer(t, "local.yaml", "defaults:\n  bastion:\n    name: mine\n")
+	before, err := yaml.Marshal(base.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, _, err := mergeLayers([]layer{base, local})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mappingValue(mappingValue(mappingValue(merged, "defaults"), "bastion"), "name").Value; got != "mine" {
+		t.Errorf("merged bastion name = %q, want mine", got)
+	}
+	after, err := yaml.Marshal(base.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("base layer changed by merge:\n%s\nwant:\n%s", after, before)
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyInheritance fills fields missing from each cluster in the merged
+// document, first from environments.<environment> and then from defaults.
+// A cluster's own value always wins. The returned slice parallels the
+// cluster list and maps each inherited field to where it came from
+// ("defaults" or "environments.<name>").
+func applyInheritance(root *yaml.Node) ([]map[string]string, error) {
+	defaults := mappingValue(root, "defaults")
+	if defaults != nil && defaults.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("defaults must be a mapping (line %d)", defaults.Line)
+	}
+	if defaults != nil && mappingValue(defaults, "name") != nil {
+		return nil, fmt.Errorf("defaults: name cannot be inherited")
+	}
+
+	envs := mappingValue(root, "environments")
+	if envs != nil && envs.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("environments must be a mapping (line %d)", envs.Line)
+	}
+	if envs != nil {
+		for i := 0; i+1 < len(envs.Content); i += 2 {
+			env := envs.Content[i+1]
+			if env.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("environments.%s must be a mapping (line %d)", envs.Content[i].Value, env.Line)
+			}
+			if mappingValue(env, "name") != nil {
+				return nil, fmt.Errorf("environments.%s: name cannot be inherited", envs.Content[i].Value)
+			}
+		}
+	}
+
+	clusters := mappingValue(root, "clusters")
+	if clusters == nil {
+		return nil, nil
+	}
+
+	inherited := make([]map[string]string, len(clusters.Content))
+	for i, c := range clusters.Content {
+		inherited[i] = make(map[string]string)
+
+		envName := ""
+		if n := mappingValue(c, "environment"); n != nil {
+			envName = n.Value
+		} else if defaults != nil {
+			if n := mappingValue(defaults, "environment"); n != nil {
+				envName = n.Value
+			}
+		}
+
+		if envs != nil && envName != "" {
+			if env := mappingValue(envs, envName); env != nil {
+				inherit(c, env, "environments."+envName, inherited[i])
+			}
+		}
+		if defaults != nil {
+			inherit(c, defaults, "defaults", inherited[i])
+		}
+	}
+	return inherited, nil
+}
+
+// inherit copies every key of src that dst does not already set.
+func inherit(dst, src *yaml.Node, origin string, inherited map[string]string) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key := src.Content[i]
+		if mappingValue(dst, key.Value) != nil {
+			continue
+		}
+		dst.Content = append(dst.Content, key, src.Content[i+1])
+		inherited[key.Value] = origin
+	}
+}
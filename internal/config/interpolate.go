@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Interpolation records one scalar whose ${VAR} references were expanded.
+type Interpolation struct {
+	File  string
+	Path  string // e.g. clusters[prod].profile
+	Raw   string // value as written
+	Value string // value after expansion
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in every scalar value under
+// root (mapping keys are left alone), in place. An unset variable without a
+// default is an error positioned at the offending value. "$${" yields a
+// literal "${".
+func interpolate(root *yaml.Node, file string) ([]Interpolation, error) {
+	var out []Interpolation
+	var walk func(n *yaml.Node, path string) error
+	walk = func(n *yaml.Node, path string) error {
+		switch n.Kind {
+		case yaml.ScalarNode:
+			if !strings.Contains(n.Value, "${") {
+				return nil
+			}
+			v, err := expandVars(n.Value, os.LookupEnv)
+			if err != nil {
+				return fmt.Errorf("%s:%d:%d: %s: %w", file, n.Line, n.Column, path, err)
+			}
+			if v != n.Value {
+				out = append(out, Interpolation{File: file, Path: path, Raw: n.Value, Value: v})
+				n.Value = v
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if err := walk(n.Content[i+1], joinPath(path, n.Content[i].Value)); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			for i, item := range n.Content {
+				idx := strconv.Itoa(i)
+				if name := mappingValue(item, "name"); item.Kind == yaml.MappingNode && name != nil {
+					idx = name.Value
+				}
+				if err := walk(item, path+"["+idx+"]"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(root, ""); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// expandVars expands ${VAR} and ${VAR:-default} in s using lookup.
+func expandVars(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		b.WriteString(s[:i])
+		expr := s[i+2 : i+end]
+		name, def, hasDef := strings.Cut(expr, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %q", s)
+		}
+		val, ok := lookup(name)
+		switch {
+		case hasDef && (!ok || val == ""):
+			val = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set (use ${%s:-default} to provide a fallback)", name, name)
+		}
+		b.WriteString(val)
+		s = s[i+end+1:]
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the config layout this build writes and expects.
+// Files without a version key are version 1.
+const CurrentVersion = 1
+
+// migration upgrades a file's top-level mapping from version from to
+// from+1. apply reports whether it changed anything.
+type migration struct {
+	from        int
+	description string
+	apply       func(root *yaml.Node) bool
+}
+
+// migrations is the upgrade chain, applied in order. Each step must only
+// rename or restructure keys so comments attached to nodes survive. It is
+// empty while version 1 is current.
+var migrations []migration
+
+// fileVersion returns the version declared in root, and whether one is.
+func fileVersion(root *yaml.Node) (int, bool, error) {
+	n := mappingValue(root, "version")
+	if n == nil {
+		return 1, false, nil
+	}
+	v, err := strconv.Atoi(n.Value)
+	if err != nil || v < 1 {
+		return 0, false, fmt.Errorf("line %d: invalid version %q", n.Line, n.Value)
+	}
+	return v, true, nil
+}
+
+// migrate upgrades root in place to CurrentVersion and returns the version
+// it started from and a description of each step that changed it. A file
+// without a version key is only taken to be older than CurrentVersion if a
+// step changes it, i.e. if it uses an older layout; otherwise it is
+// current and left as is.
+func migrate(root *yaml.Node) (int, []string, error) {
+	return migrateTo(root, CurrentVersion, migrations)
+}
+
+// migrateTo is migrate with the target version and chain as parameters.
+func migrateTo(root *yaml.Node, target int, chain []migration) (int, []string, error) {
+	from, declared, err := fileVersion(root)
+	if err != nil {
+		return 0, nil, err
+	}
+	if from > target {
+		return 0, nil, fmt.Errorf("config version %d is newer than this build supports (%d); upgrade kube-ssm-proxy", from, target)
+	}
+
+	var applied []string
+	for _, m := range chain {
+		if m.from >= from && m.from < target && m.apply(root) {
+			applied = append(applied, fmt.Sprintf("v%d → v%d: %s", m.from, m.from+1, m.description))
+		}
+	}
+	if !declared && len(applied) == 0 {
+		return target, nil, nil
+	}
+	if from < target {
+		setVersion(root, target)
+		if len(applied) == 0 {
+			applied = append(applied, fmt.Sprintf("v%d → v%d: no changes needed", from, target))
+		}
+	}
+	return from, applied, nil
+}
+
+// IncludedFiles returns path and every file it includes, recursively, in
+// merge order (includes first), for `config migrate` to upgrade each.
+func IncludedFiles(path string) ([]string, error) {
+	layers, err := loadLayers(path, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(layers))
+	for i, l := range layers {
+		files[i] = l.path
+	}
+	return files, nil
+}
+
+// MigrateFile upgrades the config file at path to CurrentVersion, stamping
+// the version on a file that has none so later migrations have a baseline.
+// Unless dryRun is set the file is rewritten in place, keeping comments; the
+// migrated document is returned either way. Included files are not touched;
+// see IncludedFiles.
+func MigrateFile(path string, dryRun bool) (from int, steps []string, out []byte, err error) {
+	return migrateFile(path, dryRun, CurrentVersion, migrations)
+}
+
+// migrateFile is MigrateFile with the target version and chain as
+// parameters.
+func migrateFile(path string, dryRun bool, target int, chain []migration) (from int, steps []string, out []byte, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("read config: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return 0, nil, nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return 0, nil, nil, fmt.Errorf("parse %s: top level must be a mapping", path)
+	}
+
+	root := doc.Content[0]
+	_, declared, _ := fileVersion(root)
+	from, steps, err = migrateTo(root, target, chain)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !declared && len(steps) == 0 {
+		setVersion(root, target)
+		steps = append(steps, fmt.Sprintf("set version: %d (the file had no version key)", target))
+	}
+	out, err = encodeYAML(&doc)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if !dryRun && len(steps) > 0 {
+		if err := writeFileAtomic(path, out); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	return from, steps, out, nil
+}
+
+// setVersion sets the version key, inserting it first if absent.
+func setVersion(root *yaml.Node, v int) {
+	val := strconv.Itoa(v)
+	if n := mappingValue(root, "version"); n != nil {
+		n.Value = val
+		n.Tag = "!!int"
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: val}
+	// Keep a file header comment above the new first key.
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
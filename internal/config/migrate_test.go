@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeConfig writes src to a clusters.yaml in a temporary directory.
+func writeConfig(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrateFileChain(t *testing.T) {
+	// A v1 → v2 step renaming fzf_height to selector_height.
+	rename := migration{from: 1, description: "rename fzf_height to selector_height",
+		apply: func(root *yaml.Node) bool {
+			for i := 0; i+1 < len(root.Content); i += 2 {
+				if k := root.Content[i]; k.Value == "fzf_height" {
+					k.Value = "selector_height"
+					return true
+				}
+			}
+			return false
+		}}
+	path := writeConfig(t, `# Team clusters
+version: 1
+fzf_height: 50% # taller selector
+clusters: [] # filled in by discover
+`)
+
+	from, steps, _, err := migrateFile(path, false, 2, []migration{rename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 1 || len(steps) != 1 || !strings.Contains(steps[0], "v1 → v2") {
+		t.Errorf("from %d, steps %q; want one v1 → v2 step", from, steps)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Team clusters", "version: 2", "selector_height: 50% # taller selector", "# filled in by discover"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("migrated file lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(string(out), "fzf_height") {
+		t.Errorf("migrated file still has fzf_height:\n%s", out)
+	}
+}
+
+func TestMigrateFileStampsVersion(t *testing.T) {
+	path := writeConfig(t, "# Team clusters\nclusters: [] # filled in by discover\n")
+
+	from, steps, _, err := MigrateFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != CurrentVersion || len(steps) != 1 {
+		t.Errorf("from %d, steps %q; want one step stamping the version", from, steps)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Team clusters\nversion: 1\nclusters: [] # filled in by discover\n"
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	if _, steps, _, err := MigrateFile(path, false); err != nil || len(steps) != 0 {
+		t.Errorf("second run: steps %q, err %v; want none", steps, err)
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvConfigPath names the environment variable that overrides config discovery.
+const EnvConfigPath = "KUBE_SSM_PROXY_CONFIG"
+
+// projectFileName is searched for in the cwd and each of its parents.
+const projectFileName = ".kube-ssm-proxy.yaml"
+
+// findConfigPath resolves the config file location. An explicit path (from
+// --config) wins, then $KUBE_SSM_PROXY_CONFIG. Otherwise the first existing
+// file in the following order is used:
+//
+//  1. .kube-ssm-proxy.yaml in the cwd or any parent directory
+//  2. $XDG_CONFIG_HOME/kube-ssm-proxy/clusters.yaml (~/.config if unset)
+//  3. clusters.yaml next to the binary
+//  4. clusters.yaml in the cwd
+func findConfigPath(explicit string) (string, error) {
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", fmt.Errorf("config file from --config: %w", err)
+		}
+		return explicit, nil
+	}
+	if p := os.Getenv(EnvConfigPath); p != "" {
+		if _, err := os.Stat(p); err != nil {
+			return "", fmt.Errorf("config file from %s: %w", EnvConfigPath, err)
+		}
+		return p, nil
+	}
+
+	candidates := searchPaths()
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("no config file found. Searched, in order:\n")
+	fmt.Fprintf(&b, "  --config flag (not set)\n")
+	fmt.Fprintf(&b, "  $%s (not set)\n", EnvConfigPath)
+	for _, p := range candidates {
+		fmt.Fprintf(&b, "  %s\n", p)
+	}
+	return "", fmt.Errorf("%s", strings.TrimRight(b.String(), "\n"))
+}
+
+// searchPaths returns the candidate config locations in priority order.
+func searchPaths() []string {
+	var paths []string
+
+	if cwd, err := os.Getwd(); err == nil {
+		for dir := cwd; ; dir = filepath.Dir(dir) {
+			paths = append(paths, filepath.Join(dir, projectFileName))
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+
+	if dir := xdgConfigHome(); dir != "" {
+		paths = append(paths, filepath.Join(dir, "kube-ssm-proxy", "clusters.yaml"))
+	}
+
+	if exe, err := os.Executable(); err == nil {
+		paths = append(paths, filepath.Join(filepath.Dir(exe), "clusters.yaml"))
+	}
+
+	if cwd, err := os.Getwd(); err == nil {
+		paths = append(paths, filepath.Join(cwd, "clusters.yaml"))
+	}
+	return paths
+}
+
+func xdgConfigHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config")
+}
+
+// ResolvePath returns the config file Load would read for explicitPath.
+func ResolvePath(explicitPath string) (string, error) {
+	return findConfigPath(explicitPath)
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// JSONSchema returns a JSON Schema (draft 2020-12) describing clusters.yaml,
+// derived from the yaml tags of the config types so it cannot drift from
+// what Load accepts. Editors with YAML language-server support can use it
+// for completion and inline errors.
+func JSONSchema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(configFile{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "kube-ssm-proxy clusters.yaml"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// requiredKeys lists keys that must be present in each cluster entry itself.
+// Other required fields may be inherited from defaults or environments.
+var requiredKeys = map[reflect.Type][]string{
+	reflect.TypeOf(ClusterConfig{}): {"name"},
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == bastionSelectorType {
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "string"},
+			schemaFor(reflect.SliceOf(bastionFilterType)),
+		}}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := yamlFields(t)
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		props := make(map[string]any, len(fields))
+		for _, name := range names {
+			props[name] = schemaFor(fields[name])
+		}
+		s := map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if req, ok := requiredKeys[t]; ok {
+			s["required"] = req
+		}
+		return s
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{}
+	}
+}
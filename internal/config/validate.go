@@ -0,0 +1,376 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single validation finding. File, Line and Column are set when
+// the offending YAML node is known. Warnings do not make the config invalid.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	msg := p.Message
+	if p.Warning {
+		msg = "warning: " + msg
+	}
+	switch {
+	case p.File != "" && p.Line > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, msg)
+	case p.File != "":
+		return fmt.Sprintf("%s: %s", p.File, msg)
+	default:
+		return msg
+	}
+}
+
+// Validate checks the config at explicitPath (or the discovered one) and
+// reports every problem found rather than stopping at the first. It flags
+// unknown keys, type errors and invalid cluster values, and includes
+// validation warnings. If knownProfiles is non-nil, each cluster's profile
+// must be one of its keys.
+//
+// The returned error is reserved for failures that prevent validation
+// altogether (no config file, unparseable YAML).
+func Validate(explicitPath string, knownProfiles map[string]bool) (string, []Problem, error) {
+	path, err := findConfigPath(explicitPath)
+	if err != nil {
+		return "", nil, err
+	}
+	doc, err := parseDocument(path)
+	if err != nil {
+		return path, nil, err
+	}
+
+	v := newValidator(doc)
+
+	// Per-file checks: unknown keys and type errors.
+	typeErrors := false
+	for _, l := range doc.layers {
+		v.checkKeys(l.root, reflect.TypeOf(configFile{}), "")
+		var cf configFile
+		if err := l.root.Decode(&cf); err != nil {
+			typeErrors = true
+			v.decodeError(l.root, l.path, err)
+		}
+	}
+	if typeErrors {
+		return path, v.problems, nil
+	}
+
+	// Checks on the merged, inherited result.
+	var cf configFile
+	if err := doc.root.Decode(&cf); err != nil {
+		v.decodeError(doc.root, path, err)
+		return path, v.problems, nil
+	}
+	doc.annotate(cf.Clusters)
+
+	if len(cf.Clusters) == 0 {
+		v.add(nil, fmt.Sprintf("no clusters defined in %s", path))
+	}
+
+	var clusterNodes []*yaml.Node
+	if n := mappingValue(doc.root, "clusters"); n != nil {
+		clusterNodes = n.Content
+	}
+	applyGlobalCredentials(cf.Clusters, cf.CredentialProvider)
+
+	if _, err := resolvePortRange(cf.PortRange); err != nil {
+		v.add(mappingValue(doc.root, "port_range"), err.Error())
+	}
+
+	seen := make(map[string]bool)
+	pinned := make(map[int]string)
+	for i := range cf.Clusters {
+		c := &cf.Clusters[i]
+		node := clusterNodes[i]
+		var warns warnings
+		err := validateCluster(c, i, &warns)
+		for _, w := range warns {
+			v.warn(v.fieldNode(node, w.Field), w.Error())
+		}
+		if err != nil {
+			var fe *fieldError
+			if errors.As(err, &fe) {
+				v.add(v.fieldNode(node, fe.Field), fe.Error())
+			} else {
+				v.add(v.fieldNode(node, ""), err.Error())
+			}
+			continue
+		}
+		if seen[c.Name] {
+			v.add(v.fieldNode(node, "name"), fmt.Sprintf("cluster %d: duplicate name %q", i, c.Name))
+		}
+		seen[c.Name] = true
+		if err := checkPinnedPorts(c, i, pinned); err != nil {
+			var fe *fieldError
+			errors.As(err, &fe)
+			v.add(v.fieldNode(node, fe.Field), fe.Error())
+		}
+
+		if knownProfiles != nil && !knownProfiles[c.Profile] {
+			v.add(v.fieldNode(node, "profile"),
+				fmt.Sprintf("cluster %d: profile %q not found in AWS config%s", i, c.Profile, c.origin("profile")))
+		}
+	}
+	if err := checkAliases(cf.Clusters); err != nil {
+		var fe *fieldError
+		errors.As(err, &fe)
+		v.add(v.fieldNode(clusterNodes[fe.Index], fe.Field), fe.Error())
+	}
+	return path, v.problems, nil
+}
+
+type validator struct {
+	nodeFile map[*yaml.Node]string
+	problems []Problem
+}
+
+// newValidator indexes the nodes of every layer of doc by file.
+func newValidator(doc *document) *validator {
+	v := &validator{nodeFile: make(map[*yaml.Node]string)}
+	for _, l := range doc.layers {
+		v.index(l.root, l.path)
+	}
+	return v
+}
+
+// index records the file every node under n was read from.
+func (v *validator) index(n *yaml.Node, file string) {
+	v.nodeFile[n] = file
+	for _, c := range n.Content {
+		v.index(c, file)
+	}
+}
+
+// problem returns a Problem positioned at n, if n is known.
+func (v *validator) problem(n *yaml.Node, msg string) Problem {
+	p := Problem{Message: msg}
+	if n != nil {
+		p.File = v.nodeFile[n]
+		if p.File == "" && n.Kind == yaml.MappingNode && len(n.Content) > 0 {
+			// Merged mappings are copies; their keys are the originals.
+			p.File = v.nodeFile[n.Content[0]]
+		}
+		p.Line = n.Line
+		p.Column = n.Column
+	}
+	return p
+}
+
+func (v *validator) add(n *yaml.Node, msg string) {
+	v.problems = append(v.problems, v.problem(n, msg))
+}
+
+func (v *validator) warn(n *yaml.Node, msg string) {
+	p := v.problem(n, msg)
+	p.Warning = true
+	v.problems = append(v.problems, p)
+}
+
+// errorAt prefixes err with the position of n, if n is known.
+func (v *validator) errorAt(n *yaml.Node, err error) error {
+	p := v.problem(n, "")
+	if p.File == "" || p.Line == 0 {
+		return err
+	}
+	return fmt.Errorf("%s:%d:%d: %w", p.File, p.Line, p.Column, err)
+}
+
+// decodeError converts a yaml decode error from decoding root (read from
+// file) into one problem per message.
+func (v *validator) decodeError(root *yaml.Node, file string, err error) {
+	v.problems = append(v.problems, v.decodeProblems(root, file, err)...)
+}
+
+// decodeProblems converts a yaml decode error into one problem per message.
+// Type errors only carry a line, so each is positioned at the node under
+// root on that line holding the offending value.
+func (v *validator) decodeProblems(root *yaml.Node, file string, err error) []Problem {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return []Problem{{File: file, Message: err.Error()}}
+	}
+	problems := make([]Problem, 0, len(te.Errors))
+	for _, msg := range te.Errors {
+		var line int
+		if _, err := fmt.Sscanf(msg, "line %d:", &line); err != nil {
+			problems = append(problems, Problem{File: file, Message: msg})
+			continue
+		}
+		_, rest, _ := strings.Cut(msg, ": ")
+		p := v.problem(nodeOnLine(root, line, rest), rest)
+		if p.File == "" {
+			p = Problem{File: file, Line: line, Column: 1, Message: rest}
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// nodeOnLine returns the node under n on line whose scalar value is quoted
+// in msg (yaml.v3 quotes it in backticks), or else the last node on line.
+func nodeOnLine(n *yaml.Node, line int, msg string) *yaml.Node {
+	var found *yaml.Node
+	var walk func(n *yaml.Node) bool
+	walk = func(n *yaml.Node) bool {
+		if n.Line == line {
+			if n.Kind == yaml.ScalarNode && strings.Contains(msg, "`"+n.Value+"`") {
+				found = n
+				return true
+			}
+			found = n
+		}
+		for _, c := range n.Content {
+			if walk(c) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(n)
+	return found
+}
+
+// fieldNode returns the value node for field in a merged cluster mapping,
+// falling back to the cluster's name (or first key) when the field is absent.
+func (v *validator) fieldNode(cluster *yaml.Node, field string) *yaml.Node {
+	if field != "" {
+		if n := mappingValue(cluster, field); n != nil {
+			return n
+		}
+	}
+	if n := mappingValue(cluster, "name"); n != nil {
+		return n
+	}
+	if len(cluster.Content) > 0 {
+		return cluster.Content[0]
+	}
+	return nil
+}
+
+// checkKeys walks n alongside the Go type it decodes into and reports
+// mapping keys that have no corresponding yaml-tagged field.
+func (v *validator) checkKeys(n *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == bastionSelectorType {
+		// A string or a list of filters; see BastionSelector.UnmarshalYAML.
+		if n.Kind == yaml.SequenceNode {
+			v.checkKeys(n, reflect.SliceOf(bastionFilterType), path)
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			ft, ok := fields[key.Value]
+			if !ok {
+				msg := fmt.Sprintf("unknown key %q", joinPath(path, key.Value))
+				if s := suggest(key.Value, fields); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				v.add(key, msg)
+				continue
+			}
+			v.checkKeys(val, ft, joinPath(path, key.Value))
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range n.Content {
+			v.checkKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v.checkKeys(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value))
+		}
+	}
+}
+
+// yamlFields maps the yaml key of every exported field of struct type t to
+// the field's type. Fields tagged yaml:"-" are omitted.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// suggest returns the known key closest to key if it is within two edits.
+func suggest(key string, known map[string]reflect.Type) string {
+	names := make([]string, 0, len(known))
+	for k := range known {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	best, bestDist := "", 3
+	for _, k := range names {
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidatePositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	src := `clusters:
+  - name: a
+    region: us-east-1
+    cluster_name: a
+    profile: p
+    use_bastion: false
+    bastion_tag: Role=jump
+  - name: b
+    region: us-east-1
+    cluster_name: b
+    profile: p
+    local_port: abc
+`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, problems, err := Validate(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Line != 12 || problems[0].Column != 17 || problems[0].Warning {
+		t.Fatalf("problems = %v, want the local_port type error at 12:17", problems)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path+":12:17:") {
+		t.Errorf("Load error = %v, want it positioned at %s:12:17", err, path)
+	}
+
+	src = strings.Replace(src, "local_port: abc", "local_port: 50000", 1)
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, problems, err = Validate(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Line != 7 || problems[0].Column != 18 || !problems[0].Warning {
+		t.Fatalf("problems = %v, want a bastion_tag warning at 7:18", problems)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Warnings) != 1 || !strings.HasPrefix(cfg.Warnings[0], path+":7:18: warning:") {
+		t.Errorf("Warnings = %q, want one positioned at %s:7:18", cfg.Warnings, path)
+	}
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MarshalClusters renders clusters as a standalone `clusters:` YAML document,
+// omitting unset fields.
+func MarshalClusters(clusters []ClusterConfig) ([]byte, error) {
+	seq := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, c := range clusters {
+		n, err := clusterNode(c)
+		if err != nil {
+			return nil, err
+		}
+		seq.Content = append(seq.Content, n)
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	setMappingValue(root, &yaml.Node{Kind: yaml.ScalarNode, Value: "clusters"}, seq)
+	return encodeYAML(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}})
+}
+
+// MergeClusters appends the clusters in found that are not already present
+// in the config at path, and returns those it added. A cluster is present if
+// an existing entry (after includes and inheritance) has the same profile,
+// region and cluster_name, so same-named clusters in other accounts are still
+// added. Existing entries are never modified; a new entry whose name is taken
+// is renamed to "<name>-<region>", then "<name>-<region>-2" and so on. The
+// file is rewritten through yaml.Node so comments and key order are preserved.
+func MergeClusters(path string, found []ClusterConfig) ([]ClusterConfig, error) {
+	doc, err := parseDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	var cf configFile
+	if err := doc.root.Decode(&cf); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	names := make(map[string]bool)
+	present := make(map[string]bool)
+	for _, c := range cf.Clusters {
+		names[c.Name] = true
+		present[clusterKey(c)] = true
+	}
+
+	var added []ClusterConfig
+	for _, c := range found {
+		if present[clusterKey(c)] {
+			continue
+		}
+		if names[c.Name] {
+			base := c.Name + "-" + c.Region
+			c.Name = base
+			for n := 2; names[c.Name]; n++ {
+				c.Name = fmt.Sprintf("%s-%d", base, n)
+			}
+		}
+		names[c.Name] = true
+		present[clusterKey(c)] = true
+		added = append(added, c)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var file yaml.Node
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(file.Content) == 0 {
+		file = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := file.Content[0]
+
+	seq := mappingValue(root, "clusters")
+	if seq == nil {
+		seq = &yaml.Node{Kind: yaml.SequenceNode}
+		setMappingValue(root, &yaml.Node{Kind: yaml.ScalarNode, Value: "clusters"}, seq)
+	}
+	for _, c := range added {
+		n, err := clusterNode(c)
+		if err != nil {
+			return nil, err
+		}
+		seq.Content = append(seq.Content, n)
+	}
+
+	out, err := encodeYAML(&file)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, out); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// clusterKey identifies the EKS cluster an entry points at.
+func clusterKey(c ClusterConfig) string {
+	return c.Profile + "|" + c.Region + "|" + c.ClusterName
+}
+
+// clusterNode encodes c as a mapping node, dropping empty fields, with
+// c.Comment above it.
+func clusterNode(c ClusterConfig) (*yaml.Node, error) {
+	var full yaml.Node
+	if err := full.Encode(c); err != nil {
+		return nil, fmt.Errorf("encode cluster %s: %w", c.Name, err)
+	}
+	n := &yaml.Node{Kind: yaml.MappingNode, HeadComment: c.Comment}
+	for i := 0; i+1 < len(full.Content); i += 2 {
+		v := full.Content[i+1]
+		if v.Tag == "!!null" || (v.Kind == yaml.ScalarNode && v.Value == "") ||
+			(v.Kind != yaml.ScalarNode && len(v.Content) == 0) {
+			continue
+		}
+		n.Content = append(n.Content, full.Content[i], v)
+	}
+	return n, nil
+}
+
+func encodeYAML(n *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return nil, fmt.Errorf("encode yaml: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encode yaml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic replaces path with data via a temp file and rename,
+// keeping the original file mode.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".clusters-*.yaml")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// discovered returns clusters as `discover` generates them: only the
+// fields it found are set.
+func discovered() []ClusterConfig {
+	private, public := true, false
+	return []ClusterConfig{
+		{Name: "prod", Region: "us-east-1", ClusterName: "prod", Profile: "prod-admin", UseBastion: &private},
+		{Name: "dev", Region: "us-east-1", ClusterName: "dev", Profile: "dev-admin", UseBastion: &public,
+			Comment: "Public endpoint limited to 203.0.113.0/24"},
+	}
+}
+
+func TestMarshalClustersOmitsUnset(t *testing.T) {
+	out, err := MarshalClusters(discovered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"local_port:", "credential_provider:", "auto_start_bastion:", "auto_stop_bastion:"} {
+		if strings.Contains(string(out), key) {
+			t.Errorf("output contains %s\n%s", key, out)
+		}
+	}
+	for _, want := range []string{"profile: prod-admin", "use_bastion: true", "use_bastion: false",
+		"# Public endpoint limited to 203.0.113.0/24\n  - name: dev"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %q\n%s", want, out)
+		}
+	}
+}
+
+func TestMergeClustersSameNameOtherAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	existing := `# team clusters
+clusters:
+  - name: main
+    region: us-east-1
+    cluster_name: main
+    profile: dev-admin
+  - name: main-us-east-1
+    region: us-east-1
+    cluster_name: legacy
+    profile: dev-admin
+`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	found := []ClusterConfig{
+		{Name: "main", Region: "us-east-1", ClusterName: "main", Profile: "dev-admin"},
+		{Name: "main", Region: "us-east-1", ClusterName: "main", Profile: "prod-admin"},
+		{Name: "main", Region: "us-east-1", ClusterName: "main", Profile: "qa-admin"},
+	}
+
+	added, err := MergeClusters(path, found)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range added {
+		got = append(got, c.Name+" "+c.Profile)
+	}
+	want := []string{"main-us-east-1-2 prod-admin", "main-us-east-1-3 qa-admin"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("added %q, want %q", got, want)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), existing) {
+		t.Errorf("existing entries or comments changed:\n%s", out)
+	}
+}
@@ -7,28 +7,44 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+
+	"kube-ssm-proxy/internal/partition"
 )
 
 // SetClusterSSM configures kubectl for an SSM-forwarded cluster.
 //   - Cluster server: https://localhost:{port} with insecure TLS
-//   - Credentials: Granted exec plugin
+//   - Credentials: exec plugin from creds (Granted by default)
 //   - Context: cluster name, switched to current
-func SetClusterSSM(contextName, clusterName, region, profile, accountID string, port int) error {
-	userName := arnUser(region, accountID, clusterName)
+func SetClusterSSM(contextName, clusterName, region, profile, accountID string, port int, creds CredentialProvider) error {
 	server := fmt.Sprintf("https://localhost:%d", port)
-
-	cmds := kubectlCommands(contextName, userName, server, clusterName, region, profile)
-	return runAll(cmds)
+	return setCluster(contextName, clusterName, region, profile, accountID, server, creds)
 }
 
 // SetClusterDirect configures kubectl for a direct-connect cluster.
 //   - Cluster server: real EKS endpoint with insecure TLS
-//   - Credentials: Granted exec plugin
+//   - Credentials: exec plugin from creds (Granted by default)
 //   - Context: cluster name, switched to current
-func SetClusterDirect(contextName, clusterName, region, profile, accountID, endpoint string) error {
+func SetClusterDirect(contextName, clusterName, region, profile, accountID, endpoint string, creds CredentialProvider) error {
+	return setCluster(contextName, clusterName, region, profile, accountID, endpoint, creds)
+}
+
+func setCluster(contextName, clusterName, region, profile, accountID, server string, creds CredentialProvider) error {
 	userName := arnUser(region, accountID, clusterName)
+	exec, err := creds.exec(templateData{
+		"Name":        contextName,
+		"ClusterName": clusterName,
+		"Region":      region,
+		"Profile":     profile,
+		"AccountID":   accountID,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Start from a clean user so settings from a previous provider don't linger.
+	_ = run("kubectl", "config", "delete-user", userName)
 
-	cmds := kubectlCommands(contextName, userName, endpoint, clusterName, region, profile)
+	cmds := kubectlCommands(contextName, userName, server, exec)
 	return runAll(cmds)
 }
 
@@ -111,9 +127,10 @@ func MarkAllLocalhostInactive() {
 }
 
 // PortsInUse returns the set of localhost ports currently assigned to
-// non-inactive clusters in kubeconfig. Used by port allocation to avoid
-// collisions with existing entries.
-func PortsInUse() map[int]bool {
+// non-inactive clusters in kubeconfig, other than the cluster named except.
+// Used by port allocation to avoid collisions with existing entries while
+// letting a cluster reclaim its own port.
+func PortsInUse(except string) map[int]bool {
 	ports := make(map[int]bool)
 	data, err := kubeconfigJSON()
 	if err != nil {
@@ -123,10 +140,11 @@ func PortsInUse() map[int]bool {
 	clusters, _ := data["clusters"].([]interface{})
 	for _, item := range clusters {
 		m, _ := item.(map[string]interface{})
+		name, _ := m["name"].(string)
 		cluster, _ := m["cluster"].(map[string]interface{})
 		server, _ := cluster["server"].(string)
 
-		if strings.HasPrefix(server, "# INACTIVE:") {
+		if strings.HasPrefix(server, "# INACTIVE:") || name == except {
 			continue
 		}
 		if strings.HasPrefix(server, "https://localhost:") {
@@ -142,31 +160,32 @@ func PortsInUse() map[int]bool {
 // --- helpers ---
 
 func arnUser(region, accountID, clusterName string) string {
-	return fmt.Sprintf("arn:aws:eks:%s:%s:cluster/%s", region, accountID, clusterName)
+	return fmt.Sprintf("arn:%s:eks:%s:%s:cluster/%s", partition.ForRegion(region), region, accountID, clusterName)
 }
 
-func kubectlCommands(contextName, userName, server, clusterName, region, profile string) [][]string {
+func kubectlCommands(contextName, userName, server string, exec execSpec) [][]string {
+	setCreds := []string{"kubectl", "config", "set-credentials", userName,
+		"--exec-command", exec.command,
+		"--exec-api-version", "client.authentication.k8s.io/v1beta1"}
+	for _, a := range exec.args {
+		setCreds = append(setCreds, "--exec-arg", a)
+	}
+	for _, e := range exec.env {
+		setCreds = append(setCreds, "--exec-env", e)
+	}
+
 	return [][]string{
 		// 1. Set cluster
 		{"kubectl", "config", "set-cluster", contextName,
 			"--server=" + server,
 			"--insecure-skip-tls-verify=true"},
-		// 2. Set credentials — exec plugin
-		{"kubectl", "config", "set-credentials", userName,
-			"--exec-command", "assume",
-			"--exec-api-version", "client.authentication.k8s.io/v1beta1",
-			"--exec-arg", profile,
-			"--exec-arg", "--exec",
-			"--exec-arg", fmt.Sprintf("aws --region %s eks get-token --cluster-name %s", region, clusterName)},
-		// 3. Set credentials — env vars
-		{"kubectl", "config", "set-credentials", userName,
-			"--exec-env", "GRANTED_QUIET=true",
-			"--exec-env", "FORCE_NO_ALIAS=true"},
-		// 4. Set context
+		// 2. Set credentials — exec plugin and its env vars
+		setCreds,
+		// 3. Set context
 		{"kubectl", "config", "set-context", contextName,
 			"--cluster", contextName,
 			"--user", userName},
-		// 5. Use context
+		// 4. Use context
 		{"kubectl", "config", "use-context", contextName},
 	}
 }
@@ -0,0 +1,139 @@
+package partition
+
+import (
+	"sort"
+	"strings"
+)
+
+// Partition names as they appear in ARNs.
+const (
+	AWS      = "aws"
+	GovCloud = "aws-us-gov"
+	China    = "aws-cn"
+	ISO      = "aws-iso"
+	ISOB     = "aws-iso-b"
+)
+
+// regions lists the known regions of each partition.
+var regions = map[string][]string{
+	AWS: {
+		"af-south-1",
+		"ap-east-1", "ap-east-2",
+		"ap-northeast-1", "ap-northeast-2", "ap-northeast-3",
+		"ap-south-1", "ap-south-2",
+		"ap-southeast-1", "ap-southeast-2", "ap-southeast-3", "ap-southeast-4",
+		"ap-southeast-5", "ap-southeast-6", "ap-southeast-7",
+		"ca-central-1", "ca-west-1",
+		"eu-central-1", "eu-central-2",
+		"eu-north-1",
+		"eu-south-1", "eu-south-2",
+		"eu-west-1", "eu-west-2", "eu-west-3",
+		"il-central-1",
+		"me-central-1", "me-south-1",
+		"mx-central-1",
+		"sa-east-1",
+		"us-east-1", "us-east-2",
+		"us-west-1", "us-west-2",
+	},
+	GovCloud: {"us-gov-east-1", "us-gov-west-1"},
+	China:    {"cn-north-1", "cn-northwest-1"},
+	ISO:      {"us-iso-east-1", "us-iso-west-1"},
+	ISOB:     {"us-isob-east-1"},
+}
+
+// byRegion is the inverse of regions.
+var byRegion = func() map[string]string {
+	m := make(map[string]string)
+	for p, rs := range regions {
+		for _, r := range rs {
+			m[r] = p
+		}
+	}
+	return m
+}()
+
+// ForRegion returns the partition a region belongs to. Regions missing from
+// the table are classified by prefix, falling back to the commercial "aws"
+// partition, so newly launched regions still get the right ARNs.
+func ForRegion(region string) string {
+	if p, ok := byRegion[region]; ok {
+		return p
+	}
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return GovCloud
+	case strings.HasPrefix(region, "cn-"):
+		return China
+	case strings.HasPrefix(region, "us-isob-"):
+		return ISOB
+	case strings.HasPrefix(region, "us-iso-"):
+		return ISO
+	default:
+		return AWS
+	}
+}
+
+// regionAreas holds the area prefixes ("ap", "us-gov") each partition's
+// known regions are named with, and regionDirections the directions
+// ("southeast") of all known regions, so regions launched after the table
+// was written are still recognised.
+var regionAreas, regionDirections = func() (map[string]map[string]bool, map[string]bool) {
+	areas := make(map[string]map[string]bool)
+	directions := make(map[string]bool)
+	for p, rs := range regions {
+		areas[p] = make(map[string]bool)
+		for _, r := range rs {
+			if area, direction, ok := splitRegion(r); ok {
+				areas[p][area] = true
+				directions[direction] = true
+			}
+		}
+	}
+	return areas, directions
+}()
+
+// splitRegion splits "us-gov-west-1" into area "us-gov" and direction
+// "west", checking that it ends in a number.
+func splitRegion(region string) (area, direction string, ok bool) {
+	labels := strings.Split(region, "-")
+	if len(labels) < 3 {
+		return "", "", false
+	}
+	n := labels[len(labels)-1]
+	if n == "" || strings.Trim(n, "0123456789") != "" {
+		return "", "", false
+	}
+	return strings.Join(labels[:len(labels)-2], "-"), labels[len(labels)-2], true
+}
+
+// KnownRegion reports whether region is in the region table.
+func KnownRegion(region string) bool {
+	_, ok := byRegion[region]
+	return ok
+}
+
+// RegionLike reports whether region, though not in the region table, is
+// named like a known one: an area its partition uses, a known direction and
+// a number, such as a newly launched "ap-southeast-8". Typos such as
+// "eu-southeast-1" pass too, so callers should warn rather than accept it
+// silently.
+func RegionLike(region string) bool {
+	area, direction, ok := splitRegion(region)
+	return ok && regionAreas[ForRegion(region)][area] && regionDirections[direction]
+}
+
+// Regions returns the known regions of partition p, sorted.
+func Regions(p string) []string {
+	rs := append([]string(nil), regions[p]...)
+	sort.Strings(rs)
+	return rs
+}
+
+// AccountFromARN returns the account ID field of an ARN, or "".
+func AccountFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
@@ -0,0 +1,29 @@
+package partition
+
+import "testing"
+
+func TestRegionChecks(t *testing.T) {
+	tests := []struct {
+		region      string
+		known, like bool
+	}{
+		{"us-east-1", true, false},
+		{"us-isob-east-1", true, false},
+		{"ap-southeast-8", false, true},
+		{"us-isob-west-1", false, true},
+		{"eu-southeast-7", false, true},
+		{"us-esat-1", false, false},
+		{"cn-south-1x", false, false},
+		{"mars-north-1", false, false},
+	}
+	for _, tt := range tests {
+		if got := KnownRegion(tt.region); got != tt.known {
+			t.Errorf("KnownRegion(%q) = %v, want %v", tt.region, got, tt.known)
+		}
+		if !tt.known {
+			if got := RegionLike(tt.region); got != tt.like {
+				t.Errorf("RegionLike(%q) = %v, want %v", tt.region, got, tt.like)
+			}
+		}
+	}
+}
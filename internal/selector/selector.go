@@ -1,9 +1,11 @@
 package selector
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"kube-ssm-proxy/internal/config"
@@ -37,7 +39,28 @@ func headlessSelect(clusters []config.ClusterConfig, selection string) (*config.
 	if c := matchCluster(clusters, selection); c != nil {
 		return c, false, nil
 	}
-	return nil, false, fmt.Errorf("HEADLESS MODE: no cluster matching %q", selection)
+
+	// Fall back to tags, which must identify a single cluster.
+	tag := strings.TrimPrefix(selection, "#")
+	var tagged []string
+	var match *config.ClusterConfig
+	for i := range clusters {
+		for _, t := range clusters[i].Tags {
+			if t == tag {
+				match = &clusters[i]
+				tagged = append(tagged, clusters[i].Name)
+				break
+			}
+		}
+	}
+	switch len(tagged) {
+	case 0:
+		return nil, false, fmt.Errorf("HEADLESS MODE: no cluster matching %q", selection)
+	case 1:
+		return match, false, nil
+	default:
+		return nil, false, fmt.Errorf("HEADLESS MODE: tag %q matches several clusters: %s", tag, strings.Join(tagged, ", "))
+	}
 }
 
 func fzfSelect(clusters []config.ClusterConfig, activeNames map[string]bool, fzfHeight string) (*config.ClusterConfig, bool, error) {
@@ -45,11 +68,16 @@ func fzfSelect(clusters []config.ClusterConfig, activeNames map[string]bool, fzf
 		options := buildOptions(clusters, activeNames)
 		input := strings.Join(options, "\n")
 
+		// Each line is "key\tdisplay"; only the display part is shown and
+		// searched, and the key identifies the selection unambiguously.
 		cmd := exec.Command("fzf",
 			"--prompt", "Cluster> ",
 			"--height", fzfHeight,
 			"--reverse",
 			"--border",
+			"--ansi",
+			"--delimiter", "\t",
+			"--with-nth", "2..",
 		)
 		cmd.Stdin = strings.NewReader(input)
 		cmd.Stderr = os.Stderr
@@ -59,39 +87,41 @@ func fzfSelect(clusters []config.ClusterConfig, activeNames map[string]bool, fzf
 			return nil, false, nil
 		}
 
-		selected := strings.TrimSpace(string(out))
-		if selected == killOption {
+		key, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+		if key == killOption {
 			return nil, true, nil
 		}
 
-		if c := matchCluster(clusters, selected); c != nil {
+		if c := matchCluster(clusters, key); c != nil {
 			return c, false, nil
 		}
 	}
 }
 
-// matchCluster finds a cluster matching a selection string, handling the
-// status prefix (●/○) and direct-connect suffix (🌏).
+// matchCluster finds a cluster by name or alias, handling the status prefix
+// (●/○) and direct-connect suffix (🌏).
 func matchCluster(clusters []config.ClusterConfig, selected string) *config.ClusterConfig {
+	selected = strings.TrimPrefix(selected, "● ")
+	selected = strings.TrimPrefix(selected, "○ ")
+	selected = strings.TrimSuffix(selected, " 🌏")
+
 	for i := range clusters {
-		c := &clusters[i]
-		icon := ""
-		if !*c.UseBastion {
-			icon = " 🌏"
+		if clusters[i].Name == selected {
+			return &clusters[i]
 		}
-		if c.Name == selected ||
-			"● "+c.Name == selected ||
-			"○ "+c.Name == selected ||
-			"● "+c.Name+icon == selected ||
-			"○ "+c.Name+icon == selected {
-			return c
+	}
+	for i := range clusters {
+		for _, a := range clusters[i].Aliases {
+			if a == selected {
+				return &clusters[i]
+			}
 		}
 	}
 	return nil
 }
 
 func buildOptions(clusters []config.ClusterConfig, activeNames map[string]bool) []string {
-	opts := []string{killOption}
+	opts := []string{killOption + "\t" + killOption}
 	for _, c := range clusters {
 		status := "○"
 		if activeNames[c.Name] {
@@ -101,7 +131,62 @@ func buildOptions(clusters []config.ClusterConfig, activeNames map[string]bool)
 		if !*c.UseBastion {
 			icon = " 🌏"
 		}
-		opts = append(opts, fmt.Sprintf("%s %s%s", status, c.Name, icon))
+		extra := ""
+		if len(c.Aliases) > 0 {
+			extra += " \033[2m(" + strings.Join(c.Aliases, ", ") + ")\033[0m"
+		}
+		for _, t := range c.Tags {
+			extra += " \033[2m#" + t + "\033[0m"
+		}
+		opts = append(opts, fmt.Sprintf("%s\t%s %s%s%s", c.Name, status, c.Name, icon, extra))
 	}
 	return opts
 }
+
+// Choose presents options in fzf under prompt and returns the index of the
+// chosen one, or -1 if the user cancelled. In headless mode the first option
+// is chosen without asking.
+func Choose(prompt string, options []string, fzfHeight string) (int, error) {
+	if os.Getenv("KUBECTL_SSM_HEADLESS_SELECTION") != "" {
+		fmt.Printf("\033[33mHEADLESS MODE: Using %s\033[0m\n", options[0])
+		return 0, nil
+	}
+
+	lines := make([]string, len(options))
+	for i, o := range options {
+		lines[i] = fmt.Sprintf("%d\t%s", i, o)
+	}
+	cmd := exec.Command("fzf",
+		"--prompt", prompt+"> ",
+		"--height", fzfHeight,
+		"--reverse",
+		"--border",
+		"--delimiter", "\t",
+		"--with-nth", "2..",
+	)
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n"))
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return -1, nil
+	}
+	key, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i >= len(options) {
+		return -1, fmt.Errorf("unexpected fzf output %q", out)
+	}
+	return i, nil
+}
+
+// Confirm asks a yes/no question on the terminal, defaulting to no. In
+// headless mode it returns false without asking.
+func Confirm(question string) bool {
+	if os.Getenv("KUBECTL_SSM_HEADLESS_SELECTION") != "" {
+		return false
+	}
+	fmt.Printf("%s [y/N] ", question)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
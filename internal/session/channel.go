@@ -0,0 +1,638 @@
+// Package session implements the client side of the SSM Session Manager
+// data channel: the websocket returned by StartSession, the binary message
+// protocol spoken over it (sequencing, acknowledgements, retransmission,
+// handshake and optional KMS encryption), and port forwarding on top.
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClientVersion is reported to the agent, which uses it to decide which
+// features the client supports. 1.2.0.0 is new enough for multiplexed port
+// forwarding.
+const ClientVersion = "1.2.0.0"
+
+// Tunables, variables so tests can shorten them.
+var (
+	// handshakeTimeout bounds the wait for the agent's handshake.
+	handshakeTimeout = 30 * time.Second
+	// resendInterval is how long a message waits for its acknowledgement
+	// before being sent again.
+	resendInterval = time.Second
+	// ackTimeout fails the channel when a message stays unacknowledged.
+	ackTimeout = 2 * time.Minute
+	// pingInterval keeps the websocket from idling out.
+	pingInterval = 5 * time.Minute
+)
+
+const (
+	// maxChunk is the largest stream-data payload sent in one message.
+	maxChunk = 1024
+	// maxUnacked bounds the messages awaiting acknowledgement; Write blocks
+	// beyond it.
+	maxUnacked = 10000
+	// maxReadBuffer bounds the stream data waiting for Read, and
+	// maxOutOfOrder the messages held back until earlier ones arrive.
+	// Output beyond either is dropped unacknowledged, so the agent resends
+	// it once there is room.
+	maxReadBuffer = 1 << 20
+	maxOutOfOrder = 1000
+)
+
+// ErrPortDisconnected is returned once by Read, in order with the stream
+// data, when the agent reports that the remote end closed the connection
+// (basic, non-multiplexed port sessions only). Reading may continue.
+var ErrPortDisconnected = errors.New("remote port closed the connection")
+
+// Options describes the session to connect to. SessionID, StreamURL and
+// Token come from the StartSession response.
+type Options struct {
+	SessionID string
+	StreamURL string
+	Token     string
+	TargetID  string // instance ID, part of the KMS encryption context
+
+	// GenerateDataKey is called when the session document requires KMS
+	// encryption. It must return a 64-byte data key for keyID under the
+	// given encryption context, in plaintext and encrypted form.
+	GenerateDataKey func(ctx context.Context, keyID string, encryptionContext map[string]string) (plaintext, ciphertext []byte, err error)
+}
+
+// Channel is an open data channel. Stream data from the agent is read with
+// Read and sent with Write.
+type Channel struct {
+	opts Options
+	ws   *wsConn
+
+	mu         sync.Mutex
+	cond       *sync.Cond // signalled on any state change below
+	nextSeq    int64
+	unacked    map[int64]*pending
+	paused     bool
+	expectSeq  int64
+	outOfOrder map[int64]*message
+	readBuf    [][]byte // nil entries mark a port disconnect
+	readBytes  int
+	enc        *encrypter
+	agent      string
+	err        error // set once the channel is finished
+
+	handshake     chan struct{} // closed when the handshake completes
+	handshakeOnce sync.Once
+	done          chan struct{} // closed when the channel is finished
+}
+
+// pending is an input message awaiting acknowledgement.
+type pending struct {
+	raw       []byte
+	firstSent time.Time
+	lastSent  time.Time
+}
+
+// Open connects to the session's data channel and completes the handshake.
+func Open(ctx context.Context, opts Options) (*Channel, error) {
+	ws, err := dialWebsocket(ctx, opts.StreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("connect data channel: %w", err)
+	}
+	c := &Channel{
+		opts:       opts,
+		ws:         ws,
+		unacked:    make(map[int64]*pending),
+		outOfOrder: make(map[int64]*message),
+		handshake:  make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	open, err := json.Marshal(openDataChannelInput{
+		MessageSchemaVersion: "1.0",
+		RequestID:            newUUID().String(),
+		TokenValue:           opts.Token,
+		ClientID:             newUUID().String(),
+		ClientVersion:        ClientVersion,
+	})
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if err := ws.WriteMessage(opText, open); err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("open data channel: %w", err)
+	}
+
+	go c.readLoop()
+	go c.resendLoop()
+	go c.pingLoop()
+
+	timer := time.NewTimer(handshakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.handshake:
+		return c, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-timer.C:
+		c.fail(errors.New("timed out waiting for the session handshake"))
+		return nil, c.Err()
+	case <-ctx.Done():
+		c.fail(ctx.Err())
+		return nil, c.Err()
+	}
+}
+
+// AgentVersion returns the SSM agent version reported in the handshake.
+func (c *Channel) AgentVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.agent
+}
+
+// Encrypted reports whether stream data is KMS-encrypted.
+func (c *Channel) Encrypted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc != nil
+}
+
+// Done is closed when the channel has finished; Err then says why.
+func (c *Channel) Done() <-chan struct{} { return c.done }
+
+// Err returns why the channel finished, io.EOF if the agent closed it
+// normally, or nil while it is open.
+func (c *Channel) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close shuts the channel down. The session itself stays alive on the SSM
+// side until it is terminated or times out.
+func (c *Channel) Close() error {
+	c.fail(errors.New("data channel closed"))
+	return nil
+}
+
+// fail finishes the channel with err unless it already finished.
+func (c *Channel) fail(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.ws.Close()
+}
+
+// Read returns stream data sent by the agent, in order, and
+// ErrPortDisconnected where the agent reported a port disconnect.
+func (c *Channel) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.readBuf) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.readBuf) == 0 {
+		return 0, c.err
+	}
+	if c.readBuf[0] == nil {
+		c.readBuf = c.readBuf[1:]
+		return 0, ErrPortDisconnected
+	}
+	n := copy(p, c.readBuf[0])
+	if n == len(c.readBuf[0]) {
+		c.readBuf = c.readBuf[1:]
+	} else {
+		c.readBuf[0] = c.readBuf[0][n:]
+	}
+	c.readBytes -= n
+	return n, nil
+}
+
+// Write sends p to the agent as stream data, split into chunks. It blocks
+// while the agent has paused publication or too many messages are
+// unacknowledged.
+func (c *Channel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxChunk)
+		if err := c.send(PayloadOutput, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// SendFlag sends a control flag to the agent.
+func (c *Channel) SendFlag(f Flag) error {
+	return c.send(PayloadFlag, binary.BigEndian.AppendUint32(nil, uint32(f)))
+}
+
+// send queues one input_stream_data message, encrypting data payloads if
+// the session is encrypted, and transmits it. It waits while publication
+// is paused or too many messages are unacknowledged.
+func (c *Channel) send(pt PayloadType, payload []byte) error {
+	return c.transmit(pt, payload, true)
+}
+
+// sendControl is send without the flow-control wait, for replies sent from
+// the read loop: only the read loop can process the acknowledgements or
+// start_publication that would end the wait.
+func (c *Channel) sendControl(pt PayloadType, payload []byte) error {
+	return c.transmit(pt, payload, false)
+}
+
+func (c *Channel) transmit(pt PayloadType, payload []byte, wait bool) error {
+	c.mu.Lock()
+	for wait && (c.paused || len(c.unacked) >= maxUnacked) && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	if pt == PayloadOutput && c.enc != nil {
+		sealed, err := c.enc.seal(payload)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		payload = sealed
+	}
+	m := &message{
+		Type:           typeInputStreamData,
+		SchemaVersion:  schemaVersion,
+		CreatedDate:    time.Now(),
+		SequenceNumber: c.nextSeq,
+		ID:             newUUID(),
+		PayloadType:    pt,
+		Payload:        payload,
+	}
+	if c.nextSeq == 0 {
+		m.Flags = 1 // first message of the stream
+	}
+	now := time.Now()
+	raw := m.marshal()
+	c.unacked[c.nextSeq] = &pending{raw: raw, firstSent: now, lastSent: now}
+	c.nextSeq++
+	c.mu.Unlock()
+
+	if err := c.ws.WriteMessage(opBinary, raw); err != nil {
+		c.fail(fmt.Errorf("send: %w", err))
+		return err
+	}
+	return nil
+}
+
+func (c *Channel) readLoop() {
+	for {
+		op, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.fail(fmt.Errorf("data channel: %w", err))
+			return
+		}
+		if op != opBinary {
+			continue
+		}
+		m, err := unmarshalMessage(data)
+		if err != nil {
+			log.Printf("Warning: dropping malformed data channel message: %v", err)
+			continue
+		}
+		if err := c.handle(m); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+func (c *Channel) handle(m *message) error {
+	switch m.Type {
+	case typeAcknowledge:
+		var ack acknowledgeContent
+		if err := json.Unmarshal(m.Payload, &ack); err != nil {
+			return fmt.Errorf("parse acknowledgement: %w", err)
+		}
+		c.mu.Lock()
+		delete(c.unacked, ack.SequenceNumber)
+		c.cond.Broadcast()
+		c.mu.Unlock()
+
+	case typeOutputStreamData:
+		c.mu.Lock()
+		full := m.SequenceNumber >= c.expectSeq &&
+			(c.readBytes >= maxReadBuffer || len(c.outOfOrder) >= maxOutOfOrder)
+		c.mu.Unlock()
+		if full {
+			return nil // not acknowledged, so the agent sends it again
+		}
+		if err := c.acknowledge(m); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if m.SequenceNumber < c.expectSeq {
+			c.mu.Unlock() // duplicate of something already delivered
+			return nil
+		}
+		c.outOfOrder[m.SequenceNumber] = m
+		var ready []*message
+		for {
+			next, ok := c.outOfOrder[c.expectSeq]
+			if !ok {
+				break
+			}
+			delete(c.outOfOrder, c.expectSeq)
+			c.expectSeq++
+			ready = append(ready, next)
+		}
+		c.mu.Unlock()
+		for _, m := range ready {
+			if err := c.process(m); err != nil {
+				return err
+			}
+		}
+
+	case typeChannelClosed:
+		var closed channelClosed
+		_ = json.Unmarshal(m.Payload, &closed)
+		if closed.Output != "" {
+			return fmt.Errorf("session closed: %s", closed.Output)
+		}
+		return io.EOF
+
+	case typePausePublication:
+		c.mu.Lock()
+		c.paused = true
+		c.mu.Unlock()
+
+	case typeStartPublication:
+		c.mu.Lock()
+		c.paused = false
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// acknowledge tells the agent m arrived.
+func (c *Channel) acknowledge(m *message) error {
+	payload, err := json.Marshal(acknowledgeContent{
+		MessageType:         m.Type,
+		MessageID:           m.ID.String(),
+		SequenceNumber:      m.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	if err != nil {
+		return err
+	}
+	ack := &message{
+		Type:          typeAcknowledge,
+		SchemaVersion: schemaVersion,
+		CreatedDate:   time.Now(),
+		Flags:         3,
+		ID:            newUUID(),
+		Payload:       payload,
+	}
+	return c.ws.WriteMessage(opBinary, ack.marshal())
+}
+
+// process handles an in-order output_stream_data message.
+func (c *Channel) process(m *message) error {
+	switch m.PayloadType {
+	case PayloadOutput:
+		data := m.Payload
+		c.mu.Lock()
+		enc := c.enc
+		c.mu.Unlock()
+		if enc != nil {
+			var err error
+			if data, err = enc.open(data); err != nil {
+				return fmt.Errorf("decrypt stream data: %w", err)
+			}
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		c.mu.Lock()
+		c.readBuf = append(c.readBuf, data)
+		c.readBytes += len(data)
+		c.cond.Broadcast()
+		c.mu.Unlock()
+
+	case PayloadHandshakeRequest:
+		return c.handleHandshake(m.Payload)
+
+	case PayloadHandshakeComplete:
+		var hc handshakeComplete
+		if err := json.Unmarshal(m.Payload, &hc); err != nil {
+			return fmt.Errorf("parse handshake completion: %w", err)
+		}
+		if hc.CustomerMessage != "" {
+			log.Printf("Session %s: %s", c.opts.SessionID, hc.CustomerMessage)
+		}
+		c.handshakeOnce.Do(func() { close(c.handshake) })
+
+	case PayloadEncChallengeRequest:
+		return c.answerChallenge(m.Payload)
+
+	case PayloadFlag:
+		if len(m.Payload) != 4 {
+			return nil
+		}
+		switch Flag(binary.BigEndian.Uint32(m.Payload)) {
+		case FlagConnectToPortError:
+			log.Printf("Warning: agent could not connect to the remote port")
+		case FlagDisconnectToPort:
+			c.mu.Lock()
+			c.readBuf = append(c.readBuf, nil)
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		}
+
+	case PayloadError, PayloadStdErr:
+		log.Printf("Agent: %s", m.Payload)
+	}
+	return nil
+}
+
+func (c *Channel) handleHandshake(payload []byte) error {
+	var req handshakeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return fmt.Errorf("parse handshake request: %w", err)
+	}
+	c.mu.Lock()
+	c.agent = req.AgentVersion
+	c.mu.Unlock()
+
+	resp := handshakeResponse{ClientVersion: ClientVersion, Errors: []string{}}
+	var enc *encrypter
+	for _, action := range req.RequestedClientActions {
+		processed := processedAction{ActionType: action.ActionType, ActionStatus: actionSuccess}
+		switch action.ActionType {
+		case "SessionType":
+			var p sessionTypeParameters
+			if err := json.Unmarshal(action.ActionParameters, &p); err != nil || p.SessionType != "Port" {
+				processed.ActionStatus = actionFailed
+				processed.Error = fmt.Sprintf("unsupported session type %q", p.SessionType)
+			}
+		case "KMSEncryption":
+			var p kmsEncryptionParameters
+			if err := json.Unmarshal(action.ActionParameters, &p); err != nil {
+				return fmt.Errorf("parse KMS parameters: %w", err)
+			}
+			var blob []byte
+			var err error
+			enc, blob, err = c.newEncrypter(p.KMSKeyID)
+			if err != nil {
+				processed.ActionStatus = actionFailed
+				processed.Error = err.Error()
+				break
+			}
+			processed.ActionResult = kmsEncryptionResult{KMSCipherTextKey: blob}
+		default:
+			processed.ActionStatus = actionUnsupported
+			processed.Error = "unsupported action " + action.ActionType
+		}
+		if processed.Error != "" {
+			resp.Errors = append(resp.Errors, processed.Error)
+		}
+		resp.ProcessedClientActions = append(resp.ProcessedClientActions, processed)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if err := c.sendControl(PayloadHandshakeResponse, data); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("session handshake failed: %v", resp.Errors)
+	}
+	// Data sent from here on is encrypted.
+	c.mu.Lock()
+	c.enc = enc
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Channel) newEncrypter(keyID string) (*encrypter, []byte, error) {
+	if c.opts.GenerateDataKey == nil {
+		return nil, nil, errors.New("session requires KMS encryption but no key generator is configured")
+	}
+	plain, blob, err := c.opts.GenerateDataKey(context.Background(), keyID, map[string]string{
+		"aws:ssm:SessionId": c.opts.SessionID,
+		"aws:ssm:TargetId":  c.opts.TargetID,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate data key: %w", err)
+	}
+	enc, err := newEncrypter(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, blob, nil
+}
+
+// answerChallenge proves to the agent that both sides hold the data key:
+// the challenge arrives encrypted with the agent's key and goes back
+// encrypted with ours.
+func (c *Channel) answerChallenge(payload []byte) error {
+	c.mu.Lock()
+	enc := c.enc
+	c.mu.Unlock()
+	if enc == nil {
+		return errors.New("encryption challenge on an unencrypted session")
+	}
+	var req encryptionChallenge
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return fmt.Errorf("parse encryption challenge: %w", err)
+	}
+	plain, err := enc.open(req.Challenge)
+	if err != nil {
+		return fmt.Errorf("decrypt encryption challenge: %w", err)
+	}
+	sealed, err := enc.seal(plain)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(encryptionChallenge{Challenge: sealed})
+	if err != nil {
+		return err
+	}
+	return c.sendControl(PayloadEncChallengeResponse, data)
+}
+
+// resendLoop retransmits unacknowledged messages, oldest first, and fails
+// the channel once one has waited longer than ackTimeout.
+func (c *Channel) resendLoop() {
+	ticker := time.NewTicker(resendInterval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		c.mu.Lock()
+		var due []int64
+		for seq, p := range c.unacked {
+			if now.Sub(p.firstSent) > ackTimeout {
+				c.mu.Unlock()
+				c.fail(fmt.Errorf("message %d not acknowledged after %s", seq, ackTimeout))
+				return
+			}
+			if now.Sub(p.lastSent) >= resendInterval {
+				due = append(due, seq)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+		raws := make([][]byte, len(due))
+		for i, seq := range due {
+			c.unacked[seq].lastSent = now
+			raws[i] = c.unacked[seq].raw
+		}
+		c.mu.Unlock()
+
+		for _, raw := range raws {
+			if err := c.ws.WriteMessage(opBinary, raw); err != nil {
+				c.fail(fmt.Errorf("resend: %w", err))
+				return
+			}
+		}
+	}
+}
+
+func (c *Channel) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteMessage(opPing, nil); err != nil {
+				c.fail(fmt.Errorf("ping: %w", err))
+				return
+			}
+		}
+	}
+}
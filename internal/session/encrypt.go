@@ -0,0 +1,56 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// encrypter seals stream data with AES-256-GCM. The KMS data key is split
+// in two: the agent encrypts with the first half and the client with the
+// second, so each direction has its own key.
+type encrypter struct {
+	encrypt, decrypt cipher.AEAD
+}
+
+func newEncrypter(dataKey []byte) (*encrypter, error) {
+	if len(dataKey) != 64 {
+		return nil, fmt.Errorf("data key is %d bytes, want 64", len(dataKey))
+	}
+	decrypt, err := newGCM(dataKey[:32])
+	if err != nil {
+		return nil, err
+	}
+	encrypt, err := newGCM(dataKey[32:])
+	if err != nil {
+		return nil, err
+	}
+	return &encrypter{encrypt: encrypt, decrypt: decrypt}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext.
+func (e *encrypter) seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, e.encrypt.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.encrypt.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (e *encrypter) open(sealed []byte) ([]byte, error) {
+	n := e.decrypt.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return e.decrypt.Open(nil, sealed[:n], sealed[n:], nil)
+}
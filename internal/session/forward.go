@@ -0,0 +1,335 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// muxAgentVersion is the first agent release that multiplexes
+// port-forwarding connections; older agents carry one connection at a time.
+const muxAgentVersion = "3.0.196.0"
+
+// Reconnection tunables, variables so tests can shorten them.
+var (
+	// reconnectDelay is the first pause between reconnection attempts; it
+	// doubles up to maxReconnectDelay.
+	reconnectDelay    = time.Second
+	maxReconnectDelay = 30 * time.Second
+	// reconnectTimeout is how long reconnection is attempted before Serve
+	// gives up and closes the listener.
+	reconnectTimeout = 10 * time.Minute
+)
+
+// errCarrierGone means a connection could not start because the channel
+// finished first; it is retried on the next channel.
+var errCarrierGone = errors.New("data channel finished")
+
+// Serve forwards connections accepted on l over ch. When ch finishes and
+// reconnect is non-nil, Serve calls it (with backoff) for a replacement
+// channel, so the local port keeps working across dropped sessions:
+// connections accepted meanwhile wait for the new channel, while those in
+// flight on the dropped one are closed. Serve returns when ctx is cancelled,
+// l fails, ch finishes without reconnect, or reconnecting has failed for
+// reconnectTimeout; the current channel and l are closed on return.
+func Serve(ctx context.Context, l net.Listener, ch *Channel, reconnect func(context.Context) (*Channel, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &server{cancel: cancel}
+	s.cond = sync.NewCond(&s.mu)
+	s.cur = newCarrier(ch)
+	go s.maintain(ctx, ch, reconnect)
+	go func() {
+		<-ctx.Done()
+		s.finish(ctx.Err())
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.finish(err)
+			return s.error()
+		}
+		go s.handle(conn)
+	}
+}
+
+// server tracks the carrier for the current channel.
+type server struct {
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	cur  carrier // nil while reconnecting
+	err  error   // why serving stopped
+}
+
+func (s *server) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// finish records why serving stopped, the first reason winning, and shuts
+// everything down.
+func (s *server) finish(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.cancel()
+}
+
+func (s *server) set(c carrier) {
+	s.mu.Lock()
+	s.cur = c
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// carrier waits for a live carrier.
+func (s *server) carrier() (carrier, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.err == nil && (s.cur == nil || s.cur.finished()) {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.cur, nil
+}
+
+func (s *server) handle(conn net.Conn) {
+	for {
+		c, err := s.carrier()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if err := c.handle(conn); err != errCarrierGone {
+			return
+		}
+	}
+}
+
+// maintain replaces ch whenever it finishes, until ctx is cancelled.
+func (s *server) maintain(ctx context.Context, ch *Channel, reconnect func(context.Context) (*Channel, error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			ch.Close()
+			return
+		case <-ch.Done():
+		}
+		s.set(nil)
+		ch.Close()
+		if reconnect == nil {
+			s.finish(ch.Err())
+			return
+		}
+		log.Printf("Data channel lost (%v); reconnecting", ch.Err())
+		next, err := reconnectWithBackoff(ctx, reconnect)
+		if err != nil {
+			s.finish(err)
+			return
+		}
+		ch = next
+		s.set(newCarrier(ch))
+	}
+}
+
+func reconnectWithBackoff(ctx context.Context, reconnect func(context.Context) (*Channel, error)) (*Channel, error) {
+	start := time.Now()
+	delay := reconnectDelay
+	for attempt := 1; ; attempt++ {
+		ch, err := reconnect(ctx)
+		if err == nil {
+			log.Printf("Reconnected after %d attempt(s) (%s)", attempt, time.Since(start).Truncate(time.Millisecond))
+			return ch, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if time.Since(start) >= reconnectTimeout {
+			return nil, fmt.Errorf("giving up reconnecting after %s: %w", reconnectTimeout, err)
+		}
+		log.Printf("Reconnect attempt %d failed: %v; retrying in %s", attempt, err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// carrier forwards local connections over one channel.
+type carrier interface {
+	// handle forwards conn, or returns errCarrierGone if the channel
+	// finished before it could.
+	handle(conn net.Conn) error
+	finished() bool
+}
+
+func newCarrier(ch *Channel) carrier {
+	if versionAtLeast(ch.AgentVersion(), muxAgentVersion) {
+		return &muxCarrier{ch: ch, sess: newMuxSession(ch)}
+	}
+	b := &basicCarrier{ch: ch}
+	go b.readLoop()
+	return b
+}
+
+func finished(ch *Channel) bool {
+	select {
+	case <-ch.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// muxCarrier runs each connection as its own smux stream.
+type muxCarrier struct {
+	ch   *Channel
+	sess *muxSession
+}
+
+func (m *muxCarrier) finished() bool { return finished(m.ch) }
+
+func (m *muxCarrier) handle(conn net.Conn) error {
+	if m.finished() {
+		return errCarrierGone
+	}
+	st, err := m.sess.Open()
+	if err != nil {
+		return errCarrierGone
+	}
+	go pipe(conn, st)
+	return nil
+}
+
+// pipe copies between conn and st until either side finishes, then closes
+// both.
+func pipe(conn net.Conn, st *muxStream) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			st.Close()
+		})
+	}
+	go func() {
+		io.Copy(conn, st)
+		closeBoth()
+	}()
+	io.Copy(st, conn)
+	closeBoth()
+}
+
+// basicCarrier serves one connection at a time over the raw stream, telling
+// the agent when each one ends so it reconnects to the remote port for the
+// next. Other connections queue.
+type basicCarrier struct {
+	ch *Channel
+
+	turn sync.Mutex // held for the duration of a connection
+
+	mu           sync.Mutex
+	current      net.Conn
+	remoteClosed bool // the agent disconnected current
+}
+
+func (b *basicCarrier) finished() bool { return finished(b.ch) }
+
+// readLoop is the only reader of the channel; data arriving between
+// connections is dropped. When the agent reports that the remote end
+// disconnected, or the channel finishes, the current connection is closed
+// so the client sees EOF.
+func (b *basicCarrier) readLoop() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := b.ch.Read(buf)
+		b.mu.Lock()
+		conn := b.current
+		if err == ErrPortDisconnected && conn != nil {
+			b.remoteClosed = true
+		}
+		b.mu.Unlock()
+		if err == ErrPortDisconnected {
+			if conn != nil {
+				conn.Close()
+			}
+			continue
+		}
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if conn != nil {
+			conn.Write(buf[:n])
+		}
+	}
+}
+
+func (b *basicCarrier) handle(conn net.Conn) error {
+	b.turn.Lock()
+	defer b.turn.Unlock()
+	if b.finished() {
+		return errCarrierGone
+	}
+	b.mu.Lock()
+	b.current = conn
+	b.remoteClosed = false
+	b.mu.Unlock()
+
+	_, err := io.Copy(b.ch, conn)
+
+	b.mu.Lock()
+	b.current = nil
+	remoteClosed := b.remoteClosed
+	b.mu.Unlock()
+	conn.Close()
+	if b.finished() || remoteClosed {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Connection from %s: %v", conn.RemoteAddr(), err)
+	}
+	if err := b.ch.SendFlag(FlagDisconnectToPort); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return nil
+}
+
+// versionAtLeast compares dotted numeric versions; an unparsable version
+// counts as old.
+func versionAtLeast(v, min string) bool {
+	a, b := strings.Split(v, "."), strings.Split(min, ".")
+	for i := range b {
+		if i >= len(a) {
+			return false
+		}
+		x, err := strconv.Atoi(a[i])
+		if err != nil {
+			return false
+		}
+		y, _ := strconv.Atoi(b[i])
+		if x != y {
+			return x > y
+		}
+	}
+	return true
+}
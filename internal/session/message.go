@@ -0,0 +1,248 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message types carried in the data channel's binary frames.
+const (
+	typeInputStreamData  = "input_stream_data"
+	typeOutputStreamData = "output_stream_data"
+	typeAcknowledge      = "acknowledge"
+	typeChannelClosed    = "channel_closed"
+	typeStartPublication = "start_publication"
+	typePausePublication = "pause_publication"
+)
+
+// PayloadType says how a stream-data payload is to be interpreted.
+type PayloadType uint32
+
+const (
+	PayloadOutput               PayloadType = 1
+	PayloadError                PayloadType = 2
+	PayloadSize                 PayloadType = 3
+	PayloadParameter            PayloadType = 4
+	PayloadHandshakeRequest     PayloadType = 5
+	PayloadHandshakeResponse    PayloadType = 6
+	PayloadHandshakeComplete    PayloadType = 7
+	PayloadEncChallengeRequest  PayloadType = 8
+	PayloadEncChallengeResponse PayloadType = 9
+	PayloadFlag                 PayloadType = 10
+	PayloadStdErr               PayloadType = 11
+	PayloadExitCode             PayloadType = 12
+)
+
+// Flag is the payload of a PayloadFlag message.
+type Flag uint32
+
+const (
+	FlagDisconnectToPort   Flag = 1
+	FlagTerminateSession   Flag = 2
+	FlagConnectToPortError Flag = 3
+)
+
+// Message layout: every field is big-endian and the header is fixed size.
+//
+//	HeaderLength   uint32   (value 116: offset of PayloadLength)
+//	MessageType    [32]byte (space padded)
+//	SchemaVersion  uint32
+//	CreatedDate    uint64   (milliseconds since the epoch)
+//	SequenceNumber int64
+//	Flags          uint64
+//	MessageID      [16]byte (UUID, low half first)
+//	PayloadDigest  [32]byte (SHA-256 of Payload)
+//	PayloadType    uint32
+//	PayloadLength  uint32
+//	Payload        []byte
+const (
+	offMessageType    = 4
+	offSchemaVersion  = 36
+	offCreatedDate    = 40
+	offSequenceNumber = 48
+	offFlags          = 56
+	offMessageID      = 64
+	offPayloadDigest  = 80
+	offPayloadType    = 112
+	offPayloadLength  = 116
+	offPayload        = 120
+
+	messageTypeLength = offSchemaVersion - offMessageType
+	headerLength      = offPayloadLength
+	schemaVersion     = 1
+)
+
+// message is one decoded data-channel message.
+type message struct {
+	Type           string
+	SchemaVersion  uint32
+	CreatedDate    time.Time
+	SequenceNumber int64
+	Flags          uint64
+	ID             uuid
+	PayloadType    PayloadType
+	Payload        []byte
+}
+
+func (m *message) marshal() []byte {
+	b := make([]byte, offPayload+len(m.Payload))
+	binary.BigEndian.PutUint32(b, headerLength)
+	copy(b[offMessageType:offSchemaVersion], bytes.Repeat([]byte{' '}, messageTypeLength))
+	copy(b[offMessageType:offSchemaVersion], m.Type)
+	binary.BigEndian.PutUint32(b[offSchemaVersion:], m.SchemaVersion)
+	binary.BigEndian.PutUint64(b[offCreatedDate:], uint64(m.CreatedDate.UnixMilli()))
+	binary.BigEndian.PutUint64(b[offSequenceNumber:], uint64(m.SequenceNumber))
+	binary.BigEndian.PutUint64(b[offFlags:], m.Flags)
+	copy(b[offMessageID:], m.ID[8:])
+	copy(b[offMessageID+8:], m.ID[:8])
+	digest := sha256.Sum256(m.Payload)
+	copy(b[offPayloadDigest:], digest[:])
+	binary.BigEndian.PutUint32(b[offPayloadType:], uint32(m.PayloadType))
+	binary.BigEndian.PutUint32(b[offPayloadLength:], uint32(len(m.Payload)))
+	copy(b[offPayload:], m.Payload)
+	return b
+}
+
+func unmarshalMessage(b []byte) (*message, error) {
+	if len(b) < offPayload {
+		return nil, fmt.Errorf("message too short (%d bytes)", len(b))
+	}
+	hl := int(binary.BigEndian.Uint32(b))
+	if hl < headerLength || hl+4 > len(b) {
+		return nil, fmt.Errorf("bad header length %d", hl)
+	}
+	m := &message{
+		Type:           strings.TrimRight(string(b[offMessageType:offSchemaVersion]), " \x00"),
+		SchemaVersion:  binary.BigEndian.Uint32(b[offSchemaVersion:]),
+		CreatedDate:    time.UnixMilli(int64(binary.BigEndian.Uint64(b[offCreatedDate:]))),
+		SequenceNumber: int64(binary.BigEndian.Uint64(b[offSequenceNumber:])),
+		Flags:          binary.BigEndian.Uint64(b[offFlags:]),
+		PayloadType:    PayloadType(binary.BigEndian.Uint32(b[offPayloadType:])),
+	}
+	copy(m.ID[8:], b[offMessageID:offMessageID+8])
+	copy(m.ID[:8], b[offMessageID+8:offPayloadDigest])
+
+	n := int(binary.BigEndian.Uint32(b[hl:]))
+	if hl+4+n > len(b) {
+		return nil, fmt.Errorf("payload length %d exceeds message", n)
+	}
+	m.Payload = b[hl+4 : hl+4+n]
+	digest := sha256.Sum256(m.Payload)
+	if !bytes.Equal(digest[:], b[offPayloadDigest:offPayloadType]) {
+		return nil, errors.New("payload digest mismatch")
+	}
+	return m, nil
+}
+
+// uuid is a random (version 4) UUID.
+type uuid [16]byte
+
+func newUUID() uuid {
+	var u uuid
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u
+}
+
+func (u uuid) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func parseUUID(s string) (uuid, error) {
+	var u uuid
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != len(u) {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+// acknowledgeContent is the JSON payload of an acknowledge message.
+type acknowledgeContent struct {
+	MessageType         string `json:"AcknowledgedMessageType"`
+	MessageID           string `json:"AcknowledgedMessageId"`
+	SequenceNumber      int64  `json:"AcknowledgedMessageSequenceNumber"`
+	IsSequentialMessage bool   `json:"IsSequentialMessage"`
+}
+
+// channelClosed is the JSON payload of a channel_closed message.
+type channelClosed struct {
+	SessionID string `json:"SessionId"`
+	Output    string `json:"Output"`
+}
+
+// openDataChannelInput is the first (text) frame sent on the websocket; it
+// authenticates the connection with the StartSession token.
+type openDataChannelInput struct {
+	MessageSchemaVersion string `json:"MessageSchemaVersion"`
+	RequestID            string `json:"RequestId"`
+	TokenValue           string `json:"TokenValue"`
+	ClientID             string `json:"ClientId"`
+	ClientVersion        string `json:"ClientVersion"`
+}
+
+// Handshake payloads. The agent lists the actions it needs from the client
+// (session type, KMS encryption) and the client reports how each went.
+type handshakeRequest struct {
+	AgentVersion           string            `json:"AgentVersion"`
+	RequestedClientActions []requestedAction `json:"RequestedClientActions"`
+}
+
+type requestedAction struct {
+	ActionType       string          `json:"ActionType"`
+	ActionParameters json.RawMessage `json:"ActionParameters"`
+}
+
+type handshakeResponse struct {
+	ClientVersion          string            `json:"ClientVersion"`
+	ProcessedClientActions []processedAction `json:"ProcessedClientActions"`
+	Errors                 []string          `json:"Errors"`
+}
+
+type processedAction struct {
+	ActionType   string `json:"ActionType"`
+	ActionStatus int    `json:"ActionStatus"`
+	ActionResult any    `json:"ActionResult"`
+	Error        string `json:"Error"`
+}
+
+// Action statuses in a handshake response.
+const (
+	actionSuccess     = 1
+	actionFailed      = 2
+	actionUnsupported = 3
+)
+
+type sessionTypeParameters struct {
+	SessionType string `json:"SessionType"`
+}
+
+type kmsEncryptionParameters struct {
+	KMSKeyID string `json:"KMSKeyId"`
+}
+
+type kmsEncryptionResult struct {
+	KMSCipherTextKey []byte `json:"KMSCipherTextKey"`
+}
+
+type handshakeComplete struct {
+	HandshakeTimeToComplete time.Duration `json:"HandshakeTimeToComplete"`
+	CustomerMessage         string        `json:"CustomerMessage"`
+}
+
+type encryptionChallenge struct {
+	Challenge []byte `json:"Challenge"`
+}
@@ -0,0 +1,251 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Newer agents multiplex port-forwarding connections over the data channel
+// with smux (github.com/xtaci/smux), protocol version 1: each frame is
+//
+//	version uint8 (1) | cmd uint8 | length uint16 LE | stream ID uint32 LE | data
+//
+// The client opens streams with SYN, sends data with PSH and closes them
+// with FIN; NOP frames keep the agent's idle timer from firing.
+const (
+	muxVersion = 1
+
+	muxSYN = 0
+	muxFIN = 1
+	muxPSH = 2
+	muxNOP = 3
+
+	muxHeaderSize   = 8
+	muxMaxFrameSize = 32768
+
+	// muxStreamBuffer bounds the data buffered for one stream. smux v1 has
+	// no per-stream flow control, so a stream whose reader falls behind
+	// stops the receive loop (and with it the whole session) until it
+	// catches up.
+	muxStreamBuffer = 1 << 20
+)
+
+// muxKeepAlive is how often a NOP is sent; the agent gives up on a session
+// that has been silent for 30 seconds.
+var muxKeepAlive = 10 * time.Second
+
+type muxSession struct {
+	rw io.ReadWriter
+
+	wmu sync.Mutex // whole frames must not interleave
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+	nextID  uint32
+	err     error
+	done    chan struct{}
+}
+
+func newMuxSession(rw io.ReadWriter) *muxSession {
+	s := &muxSession{
+		rw:      rw,
+		streams: make(map[uint32]*muxStream),
+		nextID:  1,
+		done:    make(chan struct{}),
+	}
+	go s.recvLoop()
+	go s.keepAlive()
+	return s
+}
+
+// Open starts a new stream; the agent connects it to the remote port.
+func (s *muxSession) Open() (*muxStream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return nil, err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := &muxStream{id: id, sess: s}
+	st.cond = sync.NewCond(&st.mu)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(muxSYN, id, nil); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *muxSession) writeFrame(cmd byte, id uint32, data []byte) error {
+	frame := make([]byte, muxHeaderSize, muxHeaderSize+len(data))
+	frame[0] = muxVersion
+	frame[1] = cmd
+	binary.LittleEndian.PutUint16(frame[2:], uint16(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:], id)
+	frame = append(frame, data...)
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.rw.Write(frame)
+	return err
+}
+
+func (s *muxSession) recvLoop() {
+	header := make([]byte, muxHeaderSize)
+	for {
+		if _, err := io.ReadFull(s.rw, header); err != nil {
+			s.close(err)
+			return
+		}
+		if header[0] != muxVersion {
+			s.close(errors.New("mux: unsupported protocol version"))
+			return
+		}
+		cmd := header[1]
+		data := make([]byte, binary.LittleEndian.Uint16(header[2:]))
+		id := binary.LittleEndian.Uint32(header[4:])
+		if _, err := io.ReadFull(s.rw, data); err != nil {
+			s.close(err)
+			return
+		}
+
+		s.mu.Lock()
+		st := s.streams[id]
+		s.mu.Unlock()
+		if st == nil {
+			continue
+		}
+		switch cmd {
+		case muxPSH:
+			st.push(data)
+		case muxFIN:
+			st.remoteClose()
+		}
+	}
+}
+
+func (s *muxSession) keepAlive() {
+	ticker := time.NewTicker(muxKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.writeFrame(muxNOP, 0, nil); err != nil {
+				s.close(err)
+				return
+			}
+		}
+	}
+}
+
+// close fails the session and every stream on it.
+func (s *muxSession) close(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	close(s.done)
+	streams := s.streams
+	s.streams = nil
+	s.mu.Unlock()
+	for _, st := range streams {
+		st.remoteClose()
+	}
+}
+
+// muxStream is one multiplexed connection.
+type muxStream struct {
+	id   uint32
+	sess *muxSession
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      [][]byte
+	buffered int
+	eof      bool // FIN received or session gone
+	closed   bool // Close called
+}
+
+// push buffers data for Read, waiting while the buffer is full. Data for a
+// stream that is closed meanwhile is dropped.
+func (st *muxStream) push(data []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.buffered >= muxStreamBuffer && !st.closed && !st.eof {
+		st.cond.Wait()
+	}
+	if st.closed || st.eof {
+		return
+	}
+	st.buf = append(st.buf, data)
+	st.buffered += len(data)
+	st.cond.Broadcast()
+}
+
+func (st *muxStream) remoteClose() {
+	st.mu.Lock()
+	st.eof = true
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+func (st *muxStream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for len(st.buf) == 0 && !st.eof && !st.closed {
+		st.cond.Wait()
+	}
+	if len(st.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, st.buf[0])
+	if n == len(st.buf[0]) {
+		st.buf = st.buf[1:]
+	} else {
+		st.buf[0] = st.buf[0][n:]
+	}
+	st.buffered -= n
+	st.cond.Broadcast()
+	return n, nil
+}
+
+func (st *muxStream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), muxMaxFrameSize)
+		if err := st.sess.writeFrame(muxPSH, st.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close sends FIN and forgets the stream.
+func (st *muxStream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	s := st.sess
+	s.mu.Lock()
+	delete(s.streams, st.id)
+	s.mu.Unlock()
+	return s.writeFrame(muxFIN, st.id, nil)
+}
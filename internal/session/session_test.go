@@ -0,0 +1,927 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	resendInterval = 50 * time.Millisecond
+	handshakeTimeout = 5 * time.Second
+	reconnectDelay = 10 * time.Millisecond
+	maxReconnectDelay = 50 * time.Millisecond
+}
+
+// fakeAgent is a local data-channel endpoint playing the SSM agent's side.
+// Each websocket connection runs script.
+type fakeAgent struct {
+	srv *httptest.Server
+}
+
+func newFakeAgent(t *testing.T, script func(a *agentConn)) *fakeAgent {
+	t.Helper()
+	fa := &fakeAgent{}
+	fa.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			http.Error(w, "not a websocket request", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"
+		if _, err := conn.Write([]byte(resp)); err != nil {
+			return
+		}
+		a := newAgentConn(t, &wsConn{conn: conn, r: rw.Reader})
+		if a == nil {
+			return
+		}
+		script(a)
+	}))
+	t.Cleanup(fa.srv.Close)
+	return fa
+}
+
+func (fa *fakeAgent) url() string {
+	return "ws" + strings.TrimPrefix(fa.srv.URL, "http") + "/v1/data-channel/sess-1?role=publish_subscribe"
+}
+
+// agentConn is one client connection as seen by the fake agent.
+type agentConn struct {
+	t    *testing.T
+	ws   *wsConn
+	open openDataChannelInput
+
+	mu        sync.Mutex
+	dropFirst func(seq int64) bool // leave the first copy of matching input unacknowledged
+	outSeq    int64
+	inSeen    map[int64]int
+	enc       *encrypter
+	inputs    chan *message // input_stream_data other than Output and Flag
+	acks      chan acknowledgeContent
+	flags     chan Flag
+	stream    *io.PipeWriter // decoded Output payloads, in order
+	streamR   *io.PipeReader
+	inNext    int64
+	inHeld    map[int64]*message
+}
+
+func newAgentConn(t *testing.T, ws *wsConn) *agentConn {
+	op, data, err := ws.ReadMessage()
+	if err != nil || op != opText {
+		t.Errorf("expected open-data-channel text frame, got op %d err %v", op, err)
+		return nil
+	}
+	a := &agentConn{
+		t:      t,
+		ws:     ws,
+		inSeen: make(map[int64]int),
+		inputs: make(chan *message, 100),
+		acks:   make(chan acknowledgeContent, 1000),
+		flags:  make(chan Flag, 10),
+		inHeld: make(map[int64]*message),
+	}
+	a.streamR, a.stream = io.Pipe()
+	if err := json.Unmarshal(data, &a.open); err != nil {
+		t.Errorf("parse open-data-channel input: %v", err)
+		return nil
+	}
+	go a.readLoop()
+	return a
+}
+
+func (a *agentConn) readLoop() {
+	defer a.stream.Close()
+	for {
+		op, data, err := a.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if op != opBinary {
+			continue
+		}
+		m, err := unmarshalMessage(data)
+		if err != nil {
+			a.t.Errorf("agent: bad message from client: %v", err)
+			return
+		}
+		switch m.Type {
+		case typeAcknowledge:
+			var ack acknowledgeContent
+			if err := json.Unmarshal(m.Payload, &ack); err != nil {
+				a.t.Errorf("agent: bad acknowledgement: %v", err)
+			}
+			a.acks <- ack
+		case typeInputStreamData:
+			a.mu.Lock()
+			a.inSeen[m.SequenceNumber]++
+			first := a.inSeen[m.SequenceNumber] == 1
+			drop := a.dropFirst
+			a.mu.Unlock()
+			if first && drop != nil && drop(m.SequenceNumber) {
+				continue
+			}
+			a.ack(m)
+			a.deliver(m)
+		}
+	}
+}
+
+func (a *agentConn) ack(m *message) {
+	payload, _ := json.Marshal(acknowledgeContent{
+		MessageType:         m.Type,
+		MessageID:           m.ID.String(),
+		SequenceNumber:      m.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	a.write(&message{Type: typeAcknowledge, SchemaVersion: 1, CreatedDate: time.Now(), ID: newUUID(), Payload: payload})
+}
+
+// deliver hands input messages on in sequence order, once each.
+func (a *agentConn) deliver(m *message) {
+	if m.SequenceNumber < a.inNext {
+		return
+	}
+	a.inHeld[m.SequenceNumber] = m
+	for {
+		next, ok := a.inHeld[a.inNext]
+		if !ok {
+			return
+		}
+		delete(a.inHeld, a.inNext)
+		a.inNext++
+		switch next.PayloadType {
+		case PayloadOutput:
+			data := next.Payload
+			a.mu.Lock()
+			enc := a.enc
+			a.mu.Unlock()
+			if enc != nil {
+				var err error
+				if data, err = enc.open(data); err != nil {
+					a.t.Errorf("agent: decrypt: %v", err)
+					continue
+				}
+			}
+			a.stream.Write(data)
+		case PayloadFlag:
+			a.flags <- Flag(binary.BigEndian.Uint32(next.Payload))
+		default:
+			a.inputs <- next
+		}
+	}
+}
+
+func (a *agentConn) write(m *message) {
+	if err := a.ws.WriteMessage(opBinary, m.marshal()); err != nil {
+		a.t.Logf("agent: write: %v", err)
+	}
+}
+
+// output builds the agent's next output_stream_data message.
+func (a *agentConn) output(pt PayloadType, payload []byte) *message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if pt == PayloadOutput && a.enc != nil {
+		sealed, err := a.enc.seal(payload)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		payload = sealed
+	}
+	m := &message{
+		Type:           typeOutputStreamData,
+		SchemaVersion:  1,
+		CreatedDate:    time.Now(),
+		SequenceNumber: a.outSeq,
+		ID:             newUUID(),
+		PayloadType:    pt,
+		Payload:        payload,
+	}
+	a.outSeq++
+	return m
+}
+
+func (a *agentConn) send(pt PayloadType, payload []byte) {
+	a.write(a.output(pt, payload))
+}
+
+func (a *agentConn) sendJSON(pt PayloadType, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.send(pt, data)
+}
+
+func (a *agentConn) nextInput(want PayloadType) *message {
+	select {
+	case m := <-a.inputs:
+		if m.PayloadType != want {
+			a.t.Errorf("agent: got payload type %d, want %d", m.PayloadType, want)
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		a.t.Errorf("agent: timed out waiting for payload type %d", want)
+		return nil
+	}
+}
+
+// handshake runs the agent's side of the handshake and returns the
+// client's response.
+func (a *agentConn) handshake(agentVersion string, actions ...requestedAction) *handshakeResponse {
+	actions = append([]requestedAction{{
+		ActionType:       "SessionType",
+		ActionParameters: json.RawMessage(`{"SessionType":"Port","Properties":{"portNumber":"443","type":"LocalPortForwarding"}}`),
+	}}, actions...)
+	a.sendJSON(PayloadHandshakeRequest, handshakeRequest{AgentVersion: agentVersion, RequestedClientActions: actions})
+	m := a.nextInput(PayloadHandshakeResponse)
+	if m == nil {
+		return nil
+	}
+	var resp handshakeResponse
+	if err := json.Unmarshal(m.Payload, &resp); err != nil {
+		a.t.Errorf("agent: parse handshake response: %v", err)
+		return nil
+	}
+	return &resp
+}
+
+func (a *agentConn) completeHandshake() {
+	a.sendJSON(PayloadHandshakeComplete, handshakeComplete{HandshakeTimeToComplete: time.Millisecond})
+}
+
+// wait blocks until the client disconnects.
+func (a *agentConn) wait() {
+	io.Copy(io.Discard, a.streamR)
+}
+
+func openChannel(t *testing.T, fa *fakeAgent, opts Options) *Channel {
+	t.Helper()
+	opts.StreamURL = fa.url()
+	if opts.Token == "" {
+		opts.Token = "token-1"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch, err := Open(ctx, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { ch.Close() })
+	return ch
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &message{
+		Type:           typeOutputStreamData,
+		SchemaVersion:  1,
+		CreatedDate:    time.UnixMilli(1700000000123),
+		SequenceNumber: 42,
+		Flags:          1,
+		ID:             newUUID(),
+		PayloadType:    PayloadOutput,
+		Payload:        []byte("hello"),
+	}
+	raw := m.marshal()
+	if got := binary.BigEndian.Uint32(raw); got != 116 {
+		t.Errorf("header length = %d, want 116", got)
+	}
+	if got := string(raw[4:36]); got != typeOutputStreamData+strings.Repeat(" ", 32-len(typeOutputStreamData)) {
+		t.Errorf("message type field = %q", got)
+	}
+	// The UUID's low half comes first on the wire.
+	if !bytes.Equal(raw[64:72], m.ID[8:]) || !bytes.Equal(raw[72:80], m.ID[:8]) {
+		t.Errorf("message ID not in wire order")
+	}
+
+	got, err := unmarshalMessage(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != m.Type || got.SequenceNumber != 42 || got.Flags != 1 || got.ID != m.ID ||
+		got.PayloadType != PayloadOutput || string(got.Payload) != "hello" || !got.CreatedDate.Equal(m.CreatedDate) {
+		t.Errorf("round trip mismatch: %+v", got)
+	}
+
+	raw[len(raw)-1] ^= 0xff
+	if _, err := unmarshalMessage(raw); err == nil {
+		t.Error("corrupted payload accepted")
+	}
+}
+
+func TestUUIDString(t *testing.T) {
+	u := newUUID()
+	p, err := parseUUID(u.String())
+	if err != nil || p != u {
+		t.Errorf("parseUUID(%s) = %v, %v", u, p, err)
+	}
+}
+
+func TestOpenSendsToken(t *testing.T) {
+	got := make(chan openDataChannelInput, 1)
+	fa := newFakeAgent(t, func(a *agentConn) {
+		got <- a.open
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{Token: "secret-token"})
+	open := <-got
+	if open.TokenValue != "secret-token" || open.MessageSchemaVersion != "1.0" || open.ClientVersion != ClientVersion {
+		t.Errorf("open data channel input = %+v", open)
+	}
+	if ch.AgentVersion() != "3.2.0.0" {
+		t.Errorf("AgentVersion = %q", ch.AgentVersion())
+	}
+	if ch.Encrypted() {
+		t.Error("Encrypted() = true without a KMS action")
+	}
+}
+
+func TestHandshakeRejectsSessionType(t *testing.T) {
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.sendJSON(PayloadHandshakeRequest, handshakeRequest{
+			AgentVersion: "3.2.0.0",
+			RequestedClientActions: []requestedAction{{
+				ActionType:       "SessionType",
+				ActionParameters: json.RawMessage(`{"SessionType":"Standard_Stream"}`),
+			}},
+		})
+		m := a.nextInput(PayloadHandshakeResponse)
+		var resp handshakeResponse
+		json.Unmarshal(m.Payload, &resp)
+		if len(resp.ProcessedClientActions) != 1 || resp.ProcessedClientActions[0].ActionStatus != actionFailed {
+			t.Errorf("response = %+v, want a failed SessionType action", resp)
+		}
+		a.wait()
+	})
+	_, err := Open(context.Background(), Options{StreamURL: fa.url(), Token: "t"})
+	if err == nil || !strings.Contains(err.Error(), "Standard_Stream") {
+		t.Errorf("Open error = %v, want unsupported session type", err)
+	}
+}
+
+func TestReadOrdersAndAcknowledges(t *testing.T) {
+	acked := make(chan []int64, 1)
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		m0 := a.output(PayloadOutput, []byte("zero "))
+		m1 := a.output(PayloadOutput, []byte("one "))
+		m2 := a.output(PayloadOutput, []byte("two"))
+		// Out of order, with a duplicate.
+		for _, m := range []*message{m2, m0, m0, m1} {
+			a.write(m)
+		}
+		var seqs []int64
+		timeout := time.After(5 * time.Second)
+		for len(seqs) < 6 { // handshake request + complete + 4 data
+			select {
+			case ack := <-a.acks:
+				seqs = append(seqs, ack.SequenceNumber)
+			case <-timeout:
+				t.Errorf("only %d acknowledgements", len(seqs))
+				acked <- seqs
+				return
+			}
+		}
+		acked <- seqs
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+
+	buf := make([]byte, 0, 64)
+	tmp := make([]byte, 64)
+	for len(buf) < len("zero one two") {
+		n, err := ch.Read(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, tmp[:n]...)
+	}
+	if string(buf) != "zero one two" {
+		t.Errorf("read %q", buf)
+	}
+	// The handshake used 0 and 1; data went out as 4, 2, 2, 3.
+	if seqs := <-acked; !slices.Equal(seqs, []int64{0, 1, 4, 2, 2, 3}) {
+		t.Errorf("acknowledged sequence numbers %v, want [0 1 4 2 2 3]", seqs)
+	}
+}
+
+func TestWriteResendsUntilAcknowledged(t *testing.T) {
+	got := make(chan string, 1)
+	var seen func() int
+	fa := newFakeAgent(t, func(a *agentConn) {
+		// Lose the first copy of every data message.
+		a.mu.Lock()
+		a.dropFirst = func(seq int64) bool { return seq > 0 }
+		a.mu.Unlock()
+		seen = func() int {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return a.inSeen[1]
+		}
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		buf := make([]byte, 5)
+		io.ReadFull(a.streamR, buf)
+		got <- string(buf)
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+	if _, err := ch.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-got:
+		if s != "hello" {
+			t.Errorf("agent received %q", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was never resent")
+	}
+	if n := seen(); n < 2 {
+		t.Errorf("message sent %d times, want at least 2", n)
+	}
+}
+
+func TestWriteChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	got := make(chan []byte, 1)
+	var count func() int
+	fa := newFakeAgent(t, func(a *agentConn) {
+		count = func() int {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return len(a.inSeen)
+		}
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		buf := make([]byte, len(data))
+		io.ReadFull(a.streamR, buf)
+		got <- buf
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+	if _, err := ch.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; !bytes.Equal(b, data) {
+		t.Error("data corrupted")
+	}
+	// Handshake response plus three chunks of at most 1024 bytes.
+	if n := count(); n != 4 {
+		t.Errorf("%d input messages, want 4", n)
+	}
+}
+
+func TestPausePublication(t *testing.T) {
+	resumed := make(chan struct{})
+	got := make(chan time.Time, 1)
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		a.write(&message{Type: typePausePublication, SchemaVersion: 1, CreatedDate: time.Now(), ID: newUUID()})
+		go func() {
+			buf := make([]byte, 1)
+			io.ReadFull(a.streamR, buf)
+			got <- time.Now()
+		}()
+		time.Sleep(200 * time.Millisecond)
+		close(resumed)
+		a.write(&message{Type: typeStartPublication, SchemaVersion: 1, CreatedDate: time.Now(), ID: newUUID()})
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+	time.Sleep(50 * time.Millisecond) // let the pause arrive
+	if _, err := ch.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	at := <-got
+	select {
+	case <-resumed:
+	default:
+		t.Errorf("data sent at %v while publication was paused", at)
+	}
+}
+
+// The handshake replies bypass flow control, so a pause arriving first
+// (or never lifted) cannot stall them. A repeated HandshakeComplete is
+// ignored.
+func TestHandshakeWhilePaused(t *testing.T) {
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.write(&message{Type: typePausePublication, SchemaVersion: 1, CreatedDate: time.Now(), ID: newUUID()})
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		a.completeHandshake()
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+	if v := ch.AgentVersion(); v != "3.2.0.0" {
+		t.Errorf("AgentVersion() = %q", v)
+	}
+}
+
+func TestChannelClosed(t *testing.T) {
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		payload, _ := json.Marshal(channelClosed{SessionID: "sess-1", Output: "Session terminated by admin"})
+		a.write(&message{Type: typeChannelClosed, SchemaVersion: 1, CreatedDate: time.Now(), ID: newUUID(), Payload: payload})
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+	select {
+	case <-ch.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed")
+	}
+	if err := ch.Err(); err == nil || !strings.Contains(err.Error(), "terminated by admin") {
+		t.Errorf("Err() = %v", err)
+	}
+	if _, err := ch.Read(make([]byte, 1)); err == nil {
+		t.Error("Read succeeded on a closed channel")
+	}
+}
+
+func TestKMSEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	key = append(key, bytes.Repeat([]byte{9}, 32)...)
+	blob := []byte("encrypted-data-key")
+	var gotContext map[string]string
+
+	got := make(chan string, 1)
+	fa := newFakeAgent(t, func(a *agentConn) {
+		resp := a.handshake("3.2.0.0", requestedAction{
+			ActionType:       "KMSEncryption",
+			ActionParameters: json.RawMessage(`{"KMSKeyId":"arn:aws:kms:eu-west-1:111122223333:key/abc"}`),
+		})
+		if resp == nil || len(resp.ProcessedClientActions) != 2 {
+			t.Errorf("handshake response = %+v", resp)
+			return
+		}
+		kmsAction := resp.ProcessedClientActions[1]
+		result, _ := json.Marshal(kmsAction.ActionResult)
+		var r kmsEncryptionResult
+		json.Unmarshal(result, &r)
+		if kmsAction.ActionStatus != actionSuccess || !bytes.Equal(r.KMSCipherTextKey, blob) {
+			t.Errorf("KMS action = %+v", kmsAction)
+		}
+
+		// The agent's keys are the client's, swapped.
+		enc, err := newEncrypter(append(append([]byte{}, key[32:]...), key[:32]...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.mu.Lock()
+		a.enc = enc
+		a.mu.Unlock()
+
+		challenge := []byte("prove it")
+		sealed, _ := enc.seal(challenge)
+		a.sendJSON(PayloadEncChallengeRequest, encryptionChallenge{Challenge: sealed})
+		m := a.nextInput(PayloadEncChallengeResponse)
+		var answer encryptionChallenge
+		json.Unmarshal(m.Payload, &answer)
+		if plain, err := enc.open(answer.Challenge); err != nil || !bytes.Equal(plain, challenge) {
+			t.Errorf("challenge answer %q, %v", plain, err)
+		}
+		a.completeHandshake()
+
+		a.send(PayloadOutput, []byte("secret reply"))
+		buf := make([]byte, len("secret request"))
+		io.ReadFull(a.streamR, buf)
+		got <- string(buf)
+		a.wait()
+	})
+
+	ch := openChannel(t, fa, Options{
+		SessionID: "sess-1",
+		TargetID:  "i-0123456789abcdef0",
+		GenerateDataKey: func(_ context.Context, keyID string, encCtx map[string]string) ([]byte, []byte, error) {
+			gotContext = encCtx
+			return key, blob, nil
+		},
+	})
+	if !ch.Encrypted() {
+		t.Error("Encrypted() = false")
+	}
+	if gotContext["aws:ssm:SessionId"] != "sess-1" || gotContext["aws:ssm:TargetId"] != "i-0123456789abcdef0" {
+		t.Errorf("encryption context = %v", gotContext)
+	}
+
+	buf := make([]byte, 64)
+	n, err := ch.Read(buf)
+	if err != nil || string(buf[:n]) != "secret reply" {
+		t.Errorf("Read = %q, %v", buf[:n], err)
+	}
+	if _, err := ch.Write([]byte("secret request")); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-got; s != "secret request" {
+		t.Errorf("agent decrypted %q", s)
+	}
+}
+
+func TestKMSEncryptionWithoutKeyGenerator(t *testing.T) {
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0", requestedAction{
+			ActionType:       "KMSEncryption",
+			ActionParameters: json.RawMessage(`{"KMSKeyId":"alias/ssm"}`),
+		})
+		a.wait()
+	})
+	_, err := Open(context.Background(), Options{StreamURL: fa.url(), Token: "t"})
+	if err == nil || !strings.Contains(err.Error(), "KMS") {
+		t.Errorf("Open error = %v, want a KMS error", err)
+	}
+}
+
+// muxEcho serves the agent's end of smux, echoing every stream.
+func muxEcho(a *agentConn) {
+	r := bufio.NewReader(a.streamR)
+	header := make([]byte, muxHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		data := make([]byte, binary.LittleEndian.Uint16(header[2:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		switch header[1] {
+		case muxPSH, muxFIN:
+			a.send(PayloadOutput, append(append([]byte{}, header...), data...))
+		}
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		muxEcho(a)
+	})
+	ch := openChannel(t, fa, Options{})
+	l := listen(t)
+	go Serve(t.Context(), l, ch, nil)
+
+	// Two concurrent connections, each echoed separately.
+	var wg sync.WaitGroup
+	for _, msg := range []string{"first connection", "second connection"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			echo(t, l, msg)
+		}()
+	}
+	wg.Wait()
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// echo sends msg over a new connection to l and expects it back.
+func echo(t *testing.T, l net.Listener, msg string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Errorf("echo %q, %v; want %q", buf, err, msg)
+	}
+}
+
+func TestServeBasic(t *testing.T) {
+	flags := make(chan Flag, 1)
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("2.3.0.0")
+		a.completeHandshake()
+		go func() {
+			for f := range a.flags {
+				flags <- f
+			}
+		}()
+		buf := make([]byte, 1024)
+		for {
+			n, err := a.streamR.Read(buf)
+			if err != nil {
+				return
+			}
+			a.send(PayloadOutput, buf[:n])
+		}
+	})
+	ch := openChannel(t, fa, Options{})
+	l := listen(t)
+	go Serve(t.Context(), l, ch, nil)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("echo %q, %v", buf, err)
+	}
+	conn.Close()
+
+	select {
+	case f := <-flags:
+		if f != FlagDisconnectToPort {
+			t.Errorf("flag %d, want DisconnectToPort", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no DisconnectToPort flag after the connection closed")
+	}
+}
+
+func TestServeBasicRemoteDisconnect(t *testing.T) {
+	flags := make(chan Flag, 1)
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("2.3.0.0")
+		a.completeHandshake()
+		go func() {
+			for f := range a.flags {
+				flags <- f
+			}
+		}()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(a.streamR, buf); err != nil {
+			return
+		}
+		a.send(PayloadOutput, []byte("bye"))
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(FlagDisconnectToPort))
+		a.send(PayloadFlag, payload)
+		a.wait()
+	})
+	ch := openChannel(t, fa, Options{})
+	l := listen(t)
+	go Serve(t.Context(), l, ch, nil)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("ping"))
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "bye" {
+		t.Errorf("read %q, %v; want %q then EOF", got, err, "bye")
+	}
+	select {
+	case f := <-flags:
+		t.Errorf("client sent flag %d for a connection the agent closed", f)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestServeEndsWithChannel(t *testing.T) {
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		time.Sleep(100 * time.Millisecond)
+		// Returning closes the websocket.
+	})
+	ch := openChannel(t, fa, Options{})
+	l := listen(t)
+	done := make(chan error, 1)
+	go func() { done <- Serve(t.Context(), l, ch, nil) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Serve returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve still running after the channel closed")
+	}
+}
+
+func TestServeReconnects(t *testing.T) {
+	drop := make(chan struct{})
+	var conns atomic.Int32
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+		if conns.Add(1) == 1 {
+			go muxEcho(a)
+			<-drop
+			return // closes the websocket
+		}
+		muxEcho(a)
+	})
+	ch := openChannel(t, fa, Options{})
+	reconnects := make(chan struct{}, 10)
+	reconnect := func(ctx context.Context) (*Channel, error) {
+		reconnects <- struct{}{}
+		return Open(ctx, Options{StreamURL: fa.url(), Token: "token-1"})
+	}
+	l := listen(t)
+	go Serve(t.Context(), l, ch, reconnect)
+
+	echo(t, l, "before the drop")
+	close(drop)
+	select {
+	case <-reconnects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect after the channel dropped")
+	}
+	// The same listener carries new connections over the new channel.
+	echo(t, l, "after the drop")
+	echo(t, l, "and again")
+	if n := len(reconnects); n != 0 {
+		t.Errorf("%d extra reconnects", n)
+	}
+}
+
+func TestServeGivesUp(t *testing.T) {
+	defer func(d time.Duration) { reconnectTimeout = d }(reconnectTimeout)
+	reconnectTimeout = 200 * time.Millisecond
+
+	fa := newFakeAgent(t, func(a *agentConn) {
+		a.handshake("3.2.0.0")
+		a.completeHandshake()
+	})
+	ch := openChannel(t, fa, Options{})
+	var attempts atomic.Int32
+	reconnect := func(ctx context.Context) (*Channel, error) {
+		attempts.Add(1)
+		return nil, errors.New("target not connected")
+	}
+	l := listen(t)
+	done := make(chan error, 1)
+	go func() { done <- Serve(t.Context(), l, ch, reconnect) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "giving up") {
+			t.Errorf("Serve returned %v, want giving up", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve still running after reconnecting failed")
+	}
+	if n := attempts.Load(); n < 2 {
+		t.Errorf("%d reconnect attempts, want several", n)
+	}
+	if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("listener still open after giving up")
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	for _, tt := range []struct {
+		v    string
+		want bool
+	}{
+		{"3.0.196.0", true},
+		{"3.0.1124.0", true},
+		{"3.1.0.0", true},
+		{"3.0.195.9", false},
+		{"2.3.1319.0", false},
+		{"", false},
+		{"garbage", false},
+	} {
+		if got := versionAtLeast(tt.v, muxAgentVersion); got != tt.want {
+			t.Errorf("versionAtLeast(%q) = %t, want %t", tt.v, got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package ssm
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -11,40 +14,67 @@ import (
 
 // Forward represents an active SSM port-forwarding process.
 type Forward struct {
-	PID        int
-	LocalPort  int
-	TargetHost string
-	TargetPort int
+	PID          int
+	ProcessStart time.Time // tells a reused PID apart from this process
+	LocalPort    int
+	TargetHost   string
+	TargetPort   int
+	BastionID    string // --target instance
+	Document     string // --document-name
+	Profile      string // --profile
+	Region       string // --region
+
+	// From the forward registry; Cluster is empty for forwards it does not
+	// know (e.g. started by older versions).
+	Cluster   string
+	Name      string // extra forward name; empty for the API tunnel
+	StartedAt time.Time
+}
+
+// process is a running OS process as seen by listProcesses and
+// readProcess, which are implemented per platform: from /proc on Linux,
+// from ps elsewhere.
+type process struct {
+	pid   int
+	start time.Time
+	argv  []string
+}
+
+// startTolerance absorbs the precision of start times: ps reports whole
+// seconds, and /proc start times are relative to the boot time, which moves
+// slightly when the clock is adjusted.
+const startTolerance = 2 * time.Second
+
+func sameStart(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -startTolerance && d < startTolerance
 }
 
-// ListForwards scans OS processes for active SSM port-forwarding sessions.
-// It shells out to `ps -eo pid,args` and parses lines matching the SSM
-// port-forwarding document name.
+// ListForwards scans OS processes for active SSM port-forwarding sessions
+// and attributes them to clusters from the forward registry, dropping
+// registry entries whose process is gone. It matches `kube-ssm-proxy
+// tunnel` processes, and `aws ssm start-session` processes left by older
+// versions, whose parameters include a local port, whatever the document
+// name, so sessions using custom documents are found too.
 func ListForwards() ([]Forward, error) {
-	out, err := exec.Command("ps", "-eo", "pid,args").Output()
+	ps, err := listProcesses()
 	if err != nil {
-		return nil, fmt.Errorf("ps: %w", err)
+		return nil, err
 	}
 
-	var forwards []Forward
-	seenPorts := make(map[int]bool)
-
-	for _, line := range strings.Split(string(out), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		if !strings.Contains(line, "aws") ||
-			!strings.Contains(line, "ssm") ||
-			!strings.Contains(line, "start-session") ||
-			!strings.Contains(line, "AWS-StartPortForwardingSession") {
-			continue
+	var procs []Forward
+	for _, p := range ps {
+		if f, ok := parseProcess(p); ok {
+			procs = append(procs, f)
 		}
+	}
+	// Entries are reconciled against every tunnel process, listening or
+	// not, so one briefly between sessions keeps its entry.
+	reconcile(procs)
 
-		f, ok := parseLine(line)
-		if !ok {
-			continue
-		}
+	var forwards []Forward
+	seenPorts := make(map[int]bool)
+	for _, f := range procs {
 		if seenPorts[f.LocalPort] {
 			continue
 		}
@@ -57,70 +87,149 @@ func ListForwards() ([]Forward, error) {
 	return forwards, nil
 }
 
-// parseLine extracts PID, local port, target host, and target port from a
-// ps output line.
-func parseLine(line string) (Forward, bool) {
-	// Line format: "  PID  aws ssm start-session ... --parameters host=X,portNumber=Y,localPortNumber=Z ..."
-	fields := strings.Fields(line)
-	if len(fields) < 2 {
-		return Forward{}, false
+// BastionsInUse returns the bastions of every forward process other than
+// this one, including forwards still starting (not yet listening), so a
+// bastion is not stopped under a forward that is coming up.
+func BastionsInUse() (map[string]bool, error) {
+	ps, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool)
+	for _, p := range ps {
+		if f, ok := parseProcess(p); ok && f.PID != os.Getpid() {
+			inUse[f.BastionID] = true
+		}
 	}
-	pid, err := strconv.Atoi(fields[0])
+	return inUse, nil
+}
+
+// processStart returns when pid started.
+func processStart(pid int) (time.Time, error) {
+	p, err := readProcess(pid)
 	if err != nil {
-		return Forward{}, false
+		return time.Time{}, err
+	}
+	return p.start, nil
+}
+
+// isTunnel matches a `kube-ssm-proxy tunnel` process (see StartForward).
+func isTunnel(argv []string) bool {
+	i := slices.Index(argv, "tunnel")
+	return i >= 0 && i+1 < len(argv) && argv[i+1] == "--target" &&
+		slices.Contains(argv, "--document-name")
+}
+
+// isSupervisor matches a `kube-ssm-proxy supervise` process: one running
+// this executable with the supervise command.
+func isSupervisor(argv []string) bool {
+	exe, err := os.Executable()
+	if err != nil || len(argv) == 0 || filepath.Base(argv[0]) != filepath.Base(exe) {
+		return false
 	}
+	return slices.Contains(argv[1:], "supervise")
+}
 
-	rest := strings.Join(fields[1:], " ")
+// isCLISession matches an `aws ssm start-session` process, run directly or
+// through its Python interpreter.
+func isCLISession(argv []string) bool {
+	i := slices.Index(argv, "ssm")
+	return i >= 0 && i+1 < len(argv) && argv[i+1] == "start-session" &&
+		slices.ContainsFunc(argv[:i], func(a string) bool { return filepath.Base(a) == "aws" }) &&
+		slices.Contains(argv, "--document-name")
+}
 
-	host := extractParam(rest, "host=")
-	portStr := extractParam(rest, "portNumber=")
-	localStr := extractParam(rest, "localPortNumber=")
+// parseProcess extracts the forward a tunnel or CLI session process
+// serves from its argv.
+func parseProcess(p process) (Forward, bool) {
+	if !isTunnel(p.argv) && !isCLISession(p.argv) {
+		return Forward{}, false
+	}
+	params := parseShorthand(flagValue(p.argv, "--parameters"))
+	host, localStr := params["host"], params["localPortNumber"]
 	if host == "" || localStr == "" {
 		return Forward{}, false
 	}
-
 	localPort, err := strconv.Atoi(localStr)
 	if err != nil {
 		return Forward{}, false
 	}
 	targetPort := 443
-	if portStr != "" {
+	if portStr := params["portNumber"]; portStr != "" {
 		if p, err := strconv.Atoi(portStr); err == nil {
 			targetPort = p
 		}
 	}
 
 	return Forward{
-		PID:        pid,
-		LocalPort:  localPort,
-		TargetHost: host,
-		TargetPort: targetPort,
+		PID:          p.pid,
+		ProcessStart: p.start,
+		LocalPort:    localPort,
+		TargetHost:   host,
+		TargetPort:   targetPort,
+		BastionID:    flagValue(p.argv, "--target"),
+		Document:     flagValue(p.argv, "--document-name"),
+		Profile:      flagValue(p.argv, "--profile"),
+		Region:       flagValue(p.argv, "--region"),
 	}, true
 }
 
-// extractParam pulls the value of key=value from a comma/space-delimited
-// parameter string.
-func extractParam(s, key string) string {
-	idx := strings.Index(s, key)
-	if idx < 0 {
-		return ""
+// flagValue returns the value given to a --name value or --name=value
+// argument.
+func flagValue(argv []string, name string) string {
+	for i, a := range argv {
+		if a == name && i+1 < len(argv) {
+			return argv[i+1]
+		}
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			return v
+		}
 	}
-	start := idx + len(key)
-	end := len(s)
-	for i := start; i < len(s); i++ {
-		if s[i] == ',' || s[i] == ' ' {
-			end = i
-			break
+	return ""
+}
+
+// parseShorthand parses the aws CLI's k=v,k=v parameter form, keeping the
+// first value of each key.
+func parseShorthand(s string) map[string]string {
+	params := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if _, seen := params[k]; !seen {
+			params[k] = v
 		}
 	}
-	return s[start:end]
+	return params
+}
+
+// PortRange is an inclusive range of local ports used for allocation.
+type PortRange struct {
+	Min, Max int
+}
+
+// DefaultPortRange is the IANA dynamic/private port range.
+var DefaultPortRange = PortRange{Min: 49152, Max: 65535}
+
+// PortFor returns the deterministic starting port for seed (typically the
+// cluster name) within r, so a cluster gets the same port every session
+// unless something else is already using it.
+func PortFor(seed string, r PortRange) int {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	return r.Min + int(h.Sum32()%uint32(r.Max-r.Min+1))
 }
 
-// FindAvailablePort returns the first free TCP port in [49152, 65535].
-// It skips ports that are already listening AND ports in the reserved set
-// (typically ports already assigned in kubeconfig).
-func FindAvailablePort(reserved map[int]bool) (int, error) {
-	for port := 49152; port <= 65535; port++ {
+// FindAvailablePort returns the first free TCP port in r, probing upward
+// from PortFor(seed, r) and wrapping around. It skips ports that are
+// already listening AND ports in the reserved set (typically ports already
+// assigned in kubeconfig).
+func FindAvailablePort(seed string, r PortRange, reserved map[int]bool) (int, error) {
+	size := r.Max - r.Min + 1
+	start := PortFor(seed, r)
+	for i := 0; i < size; i++ {
+		port := r.Min + (start-r.Min+i)%size
 		if reserved[port] {
 			continue
 		}
@@ -128,7 +237,7 @@ func FindAvailablePort(reserved map[int]bool) (int, error) {
 			return port, nil
 		}
 	}
-	return 0, fmt.Errorf("no available port in range 49152-65535")
+	return 0, fmt.Errorf("no available port in range %d-%d", r.Min, r.Max)
 }
 
 // IsPortListening returns true if a TCP connect to localhost:port succeeds.
@@ -0,0 +1,108 @@
+package ssm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of start times in /proc/PID/stat. It is
+// 100 on every architecture the kernel exposes to userspace in practice.
+const clockTicks = 100
+
+// listProcesses reads every process from /proc. Processes that exit while
+// being read, kernel threads and zombies (which have no argv) are skipped.
+func listProcesses() ([]process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+	var procs []process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		p, err := readProc(pid, boot)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// readProcess reads a single process from /proc.
+func readProcess(pid int) (process, error) {
+	boot, err := bootTime()
+	if err != nil {
+		return process{}, err
+	}
+	return readProc(pid, boot)
+}
+
+func readProc(pid int, boot time.Time) (process, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+	// argv is NUL-separated, so arguments containing spaces stay intact.
+	cmdline, err := os.ReadFile(dir + "/cmdline")
+	if err != nil {
+		return process{}, err
+	}
+	if len(cmdline) == 0 {
+		return process{}, fmt.Errorf("process %d has no command line", pid)
+	}
+	argv := strings.Split(string(bytes.TrimSuffix(cmdline, []byte{0})), "\x00")
+
+	stat, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return process{}, err
+	}
+	// The command name (field 2) is parenthesised and may contain spaces
+	// or parentheses, so fields are counted from the last ')': field 3
+	// (state) comes first and starttime is field 22.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return process{}, fmt.Errorf("%s/stat: unexpected format", dir)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 22-2 {
+		return process{}, fmt.Errorf("%s/stat: unexpected format", dir)
+	}
+	ticks, err := strconv.ParseUint(fields[22-3], 10, 64)
+	if err != nil {
+		return process{}, fmt.Errorf("%s/stat: %w", dir, err)
+	}
+	start := boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+	return process{pid: pid, start: start, argv: argv}, nil
+}
+
+// bootTime reads the btime line of /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("/proc/stat: %w", err)
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("/proc/stat: no btime")
+}
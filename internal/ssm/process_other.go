@@ -0,0 +1,72 @@
+//go:build !linux
+
+package ssm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Without /proc, processes come from ps. Its args column joins argv with
+// spaces, so arguments are split on whitespace again; the arguments
+// StartForward passes contain none.
+
+// listProcesses runs `ps -eo pid,lstart,args`.
+func listProcesses() ([]process, error) {
+	out, err := psCommand("-eo", "pid,lstart,args").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ps: %w", err)
+	}
+	var procs []process
+	for _, line := range strings.Split(string(out), "\n") {
+		if p, ok := parsePsLine(line); ok {
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+// readProcess runs ps for a single PID.
+func readProcess(pid int) (process, error) {
+	out, err := psCommand("-o", "pid=,lstart=,args=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return process{}, fmt.Errorf("ps -p %d: %w", pid, err)
+	}
+	p, ok := parsePsLine(string(out))
+	if !ok {
+		return process{}, fmt.Errorf("ps -p %d: unexpected output %q", pid, out)
+	}
+	return p, nil
+}
+
+// psCommand runs ps in the C locale so lstart has a fixed format.
+func psCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("ps", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+// lstartFields is the number of words in ps's lstart column, e.g.
+// "Thu Oct 16 10:04:05 2026".
+const lstartFields = 5
+
+// parsePsLine parses "  PID  Thu Oct 16 10:04:05 2026 ARGS...".
+func parsePsLine(line string) (process, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2+lstartFields {
+		return process{}, false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return process{}, false
+	}
+	start, err := time.ParseInLocation("Mon Jan 2 15:04:05 2006", strings.Join(fields[1:1+lstartFields], " "), time.Local)
+	if err != nil {
+		return process{}, false
+	}
+	return process{pid: pid, start: start, argv: fields[1+lstartFields:]}, true
+}
@@ -0,0 +1,194 @@
+package ssm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// registryEntry is a forward recorded in the registry file. PID and
+// ProcessStart together identify the process, so a reused PID does not
+// inherit another forward's entry.
+type registryEntry struct {
+	Cluster      string    `json:"cluster"`
+	Forward      string    `json:"forward,omitempty"` // extra forward name; empty for the API tunnel
+	PID          int       `json:"pid"`
+	ProcessStart time.Time `json:"process_start"`
+	Port         int       `json:"port"`
+	Bastion      string    `json:"bastion"`
+	Profile      string    `json:"profile,omitempty"`
+	Region       string    `json:"region"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// Register records f in the forward registry under f.Cluster and f.Name,
+// replacing any entry for the same port. f.StartedAt defaults to when the
+// process started.
+func Register(f Forward) error {
+	start := f.ProcessStart
+	if start.IsZero() {
+		var err error
+		if start, err = processStart(f.PID); err != nil {
+			return err
+		}
+	}
+	startedAt := f.StartedAt
+	if startedAt.IsZero() {
+		startedAt = start
+	}
+	entry := registryEntry{
+		Cluster:      f.Cluster,
+		Forward:      f.Name,
+		PID:          f.PID,
+		ProcessStart: start,
+		Port:         f.LocalPort,
+		Bastion:      f.BastionID,
+		Profile:      f.Profile,
+		Region:       f.Region,
+		StartedAt:    startedAt,
+	}
+	return updateRegistry(func(entries []registryEntry) ([]registryEntry, bool) {
+		keep := []registryEntry{entry}
+		for _, e := range entries {
+			if e.Port != f.LocalPort {
+				keep = append(keep, e)
+			}
+		}
+		return keep, true
+	})
+}
+
+// reconcile attaches registry entries to the running processes they
+// describe and drops entries whose process is gone or whose PID now
+// belongs to a different process. An entry whose process started after
+// procs was listed is kept. The file is rewritten only if an entry was
+// dropped.
+func reconcile(procs []Forward) {
+	byPID := make(map[int]int, len(procs))
+	for i, p := range procs {
+		byPID[p.PID] = i
+	}
+	err := updateRegistry(func(entries []registryEntry) ([]registryEntry, bool) {
+		var keep []registryEntry
+		for _, e := range entries {
+			i, ok := byPID[e.PID]
+			if !ok {
+				if p, err := readProcess(e.PID); err == nil && sameStart(p.start, e.ProcessStart) {
+					keep = append(keep, e)
+				}
+				continue
+			}
+			if procs[i].LocalPort != e.Port || !sameStart(procs[i].ProcessStart, e.ProcessStart) {
+				continue
+			}
+			keep = append(keep, e)
+			p := &procs[i]
+			p.Cluster = e.Cluster
+			p.Name = e.Forward
+			p.StartedAt = e.StartedAt
+			if p.Profile == "" {
+				p.Profile = e.Profile
+			}
+			if p.Region == "" {
+				p.Region = e.Region
+			}
+		}
+		return keep, len(keep) != len(entries)
+	})
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// updateRegistry runs fn on the recorded forwards and, if fn reports a
+// change, writes back what it returns. The whole load, update and save
+// happens under an exclusive lock on a sidecar file, so concurrent
+// kube-ssm-proxy processes do not lose each other's updates.
+func updateRegistry(fn func([]registryEntry) ([]registryEntry, bool)) error {
+	path := registryPath() + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close() // releases the lock
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s: %w", path, err)
+	}
+	entries, changed := fn(readRegistry())
+	if !changed {
+		return nil
+	}
+	return writeRegistry(entries)
+}
+
+// readRegistry returns the recorded forwards; a missing or unreadable file
+// means none.
+func readRegistry() []registryEntry {
+	data, err := os.ReadFile(registryPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: %v", err)
+		}
+		return nil
+	}
+	var entries []registryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("Warning: parse %s: %v", registryPath(), err)
+		return nil
+	}
+	return entries
+}
+
+// writeRegistry replaces the registry file; callers hold the lock (see
+// updateRegistry). It is written to a temporary file and renamed, so
+// readers never see a partial file.
+func writeRegistry(entries []registryEntry) error {
+	path := registryPath()
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".forwards-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func registryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".cache", "kube-ssm-proxy", "forwards.json")
+}
@@ -5,14 +5,24 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
+
+	"kube-ssm-proxy/internal/aws"
 )
 
+// NewTimestampWriter returns a writer that prefixes each line written to w
+// with a timestamp, in the format of the session log files.
+func NewTimestampWriter(w io.Writer) io.Writer {
+	return &timestampWriter{w: w}
+}
+
 // timestampWriter wraps an io.Writer and prepends a timestamp to each line.
 type timestampWriter struct {
 	w   io.Writer
@@ -41,72 +51,150 @@ func (tw *timestampWriter) Write(p []byte) (int, error) {
 	return total, nil
 }
 
-// StartForward launches an SSM port-forwarding session as a detached process.
-// It allocates a port that is both free (not listening) and not already in
-// kubeconfig, marks any stale kubeconfig entries for that port as inactive,
-// starts the process, and waits for the port to become reachable by polling
-// every 2 seconds for up to 120 seconds.
+// ForwardOptions describes an SSM port-forwarding session to start.
+type ForwardOptions struct {
+	ClusterName string
+	Name        string // extra forward name; empty for the Kubernetes API tunnel
+	BastionID   string
+	TargetHost  string
+	TargetPort  int // remote port; 443 if zero
+	LocalPort   int // fixed local port; allocated if zero
+	Profile     string
+	Region      string
+
+	// Document is the SSM session document; DefaultDocument if empty. Its
+	// Parameters are passed alongside host/portNumber/localPortNumber.
+	Document   string
+	Parameters map[string]string
+
+	// PortSeed makes allocation deterministic: the search starts at
+	// PortFor(PortSeed, PortRange). Typically the cluster (or forward) name.
+	PortSeed string
+	// PortRange bounds allocation; DefaultPortRange if zero.
+	PortRange PortRange
+	// ReservedPorts are skipped during allocation (typically ports already
+	// assigned in kubeconfig).
+	ReservedPorts map[int]bool
+	// MarkInactive, if set, is called with the chosen port before the
+	// session starts so stale kubeconfig entries for it can be retired.
+	MarkInactive func(int)
+	// Role is assumed on top of Profile by the tunnel process.
+	Role aws.AssumeRole
+}
+
+// DefaultDocument is the AWS-managed port-forwarding session document.
+const DefaultDocument = "AWS-StartPortForwardingSessionToRemoteHost"
+
+// ExternalIDEnv carries the role's external ID to the tunnel process. It is
+// passed in the environment rather than argv, which any local user can read.
+const ExternalIDEnv = "KUBE_SSM_PROXY_EXTERNAL_ID"
+
+// StartForward launches an SSM port-forwarding session as a detached
+// `kube-ssm-proxy tunnel` process.
+// Unless opts.LocalPort pins one, it allocates a port that is both free (not
+// listening) and not reserved, starting from the seed-derived port so the
+// same cluster lands on the same port across sessions. It then marks any
+// stale kubeconfig entries for that port as inactive, starts the process,
+// and waits for the port to become reachable by polling every 2 seconds for
+// up to 120 seconds. A forward that comes up is recorded in the forward
+// registry under opts.ClusterName and opts.Name.
 //
-// stderr is captured to a temp log file so failures are visible.
-func StartForward(
-	clusterName, bastionID, targetHost, profile, region string,
-	reservedPorts map[int]bool,
-	markInactive func(int),
-	attempt, maxAttempts int,
-) (int, error) {
-	port, err := FindAvailablePort(reservedPorts)
-	if err != nil {
-		return 0, err
+// The tunnel's output goes to a log file so failures are visible.
+func StartForward(opts ForwardOptions, attempt, maxAttempts int) (int, error) {
+	port := opts.LocalPort
+	if port == 0 {
+		r := opts.PortRange
+		if r == (PortRange{}) {
+			r = DefaultPortRange
+		}
+		var err error
+		port, err = FindAvailablePort(opts.PortSeed, r, opts.ReservedPorts)
+		if err != nil {
+			return 0, err
+		}
+	} else if IsPortListening(port) {
+		return 0, fmt.Errorf("local port %d is already in use", port)
 	}
 
 	// Mark any existing clusters using this port as inactive
-	if markInactive != nil {
-		markInactive(port)
+	if opts.MarkInactive != nil {
+		opts.MarkInactive(port)
 	}
 
 	// Strip https:// from target host
-	host := strings.TrimPrefix(targetHost, "https://")
+	host := strings.TrimPrefix(opts.TargetHost, "https://")
+	remotePort := opts.TargetPort
+	if remotePort == 0 {
+		remotePort = 443
+	}
 
-	params := fmt.Sprintf("host=%s,portNumber=443,localPortNumber=%d", host, port)
+	document := opts.Document
+	if document == "" {
+		document = DefaultDocument
+	}
+	params := fmt.Sprintf("host=%s,portNumber=%d,localPortNumber=%d", host, remotePort, port)
+	for _, k := range slices.Sorted(maps.Keys(opts.Parameters)) {
+		params += fmt.Sprintf(",%s=%s", k, opts.Parameters[k])
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("locate own executable: %w", err)
+	}
+	// The tunnel subcommand takes the aws CLI's flags, in the order
+	// ListForwards looks for.
 	args := []string{
-		"ssm", "start-session",
-		"--target", bastionID,
-		"--document-name", "AWS-StartPortForwardingSessionToRemoteHost",
+		"tunnel",
+		"--target", opts.BastionID,
+		"--document-name", document,
 		"--parameters", params,
-		"--profile", profile,
-		"--region", region,
+		"--region", opts.Region,
+	}
+	if opts.Profile != "" {
+		args = append(args, "--profile", opts.Profile)
+	}
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, ExternalIDEnv+"=")
+	})
+	if opts.Role.ARN != "" {
+		args = append(args, "--role-arn", opts.Role.ARN)
+		if opts.Role.ExternalID != "" {
+			env = append(env, ExternalIDEnv+"="+opts.Role.ExternalID)
+		}
+		if opts.Role.SessionName != "" {
+			args = append(args, "--role-session-name", opts.Role.SessionName)
+		}
 	}
 
-	cmd := exec.Command("aws", args...)
+	cmd := exec.Command(exe, args...)
+	cmd.Env = env
 
 	// Detach into its own process group so it survives parent exit
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	// Capture stderr to a log file for debugging
-	logDir := ssmLogDir()
+	// The tunnel logs to the file directly; a pipe through this process
+	// would break when it exits.
+	logDir := LogDir()
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
 	logPath := filepath.Join(logDir, fmt.Sprintf("ssm-port-%d_%s.log", port, timestamp))
 	logFile, err := os.Create(logPath)
 	if err != nil {
 		return 0, fmt.Errorf("create ssm log file: %w", err)
 	}
-	tsWriter := &timestampWriter{w: logFile}
+	defer logFile.Close()
 
 	// Write connection context header for debugging
 	ts := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Fprintf(logFile, "[%s] cluster=%s region=%s profile=%s bastion=%s target=%s port=%d attempt=%d/%d\n",
-		ts, clusterName, region, profile, bastionID, host, port, attempt, maxAttempts)
+	fmt.Fprintf(logFile, "[%s] cluster=%s forward=%s region=%s profile=%s bastion=%s document=%s target=%s:%d port=%d attempt=%d/%d\n",
+		ts, opts.ClusterName, opts.Name, opts.Region, opts.Profile, opts.BastionID, document, host, remotePort, port, attempt, maxAttempts)
 
-	cmd.Stdout = tsWriter
-	cmd.Stderr = tsWriter
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
 
 	if err := cmd.Start(); err != nil {
-		logFile.Close()
 		return 0, fmt.Errorf("start ssm session: %w", err)
 	}
 
-	log.Printf("SSM process started with PID: %d (log: %s)", cmd.Process.Pid, logPath)
-	// Don't close logFile — the detached process continues writing to it via tsWriter
+	log.Printf("SSM tunnel started with PID: %d (log: %s)", cmd.Process.Pid, logPath)
 
 	// Wait for the process in a goroutine so we can detect early exit.
 	// Without this, the zombie process keeps isProcessAlive returning true.
@@ -127,20 +215,32 @@ func StartForward(
 		if IsPortListening(port) {
 			fmt.Fprintf(os.Stderr, "\r\033[K") // clear spinner line
 			log.Printf("SSM port forward ready on port %d (took %s)", port, time.Since(start).Truncate(time.Second))
+			if err := Register(Forward{
+				PID:       cmd.Process.Pid,
+				LocalPort: port,
+				BastionID: opts.BastionID,
+				Profile:   opts.Profile,
+				Region:    opts.Region,
+				Cluster:   opts.ClusterName,
+				Name:      opts.Name,
+				StartedAt: start,
+			}); err != nil {
+				log.Printf("Warning: cannot record forward on port %d: %v", port, err)
+			}
 			return port, nil
 		}
 		// Check if process died early
 		select {
 		case <-exited:
 			fmt.Fprintf(os.Stderr, "\r\033[K") // clear spinner line
-			logContent, _ := os.ReadFile(logFile.Name())
-			return 0, fmt.Errorf("SSM process (PID %d) died. Log:\n%s", cmd.Process.Pid, string(logContent))
+			logContent, _ := os.ReadFile(logPath)
+			return 0, fmt.Errorf("SSM tunnel (PID %d) died. Log:\n%s", cmd.Process.Pid, string(logContent))
 		default:
 		}
 	}
 
 	fmt.Fprintf(os.Stderr, "\r\033[K") // clear spinner line
-	logContent, _ := os.ReadFile(logFile.Name())
+	logContent, _ := os.ReadFile(logPath)
 	return 0, fmt.Errorf("port %d not listening after 120s. SSM log:\n%s", port, string(logContent))
 }
 
@@ -153,14 +253,47 @@ func StopAll() int {
 	}
 	count := 0
 	for _, f := range forwards {
-		if killProcess(f.PID) {
+		if killProcess(f) {
 			count++
 		}
 	}
 	return count
 }
 
-// PruneDuplicates ensures at most one forward per target host.
+// StopSupervisors terminates every running `kube-ssm-proxy supervise`
+// process and waits up to killGrace for them to exit, so forwards stopped
+// afterwards are not restarted. It returns how many were signalled.
+func StopSupervisors() int {
+	ps, err := listProcesses()
+	if err != nil {
+		log.Printf("Warning: failed to list processes: %v", err)
+		return 0
+	}
+	var stopped []process
+	for _, p := range ps {
+		if p.pid == os.Getpid() || !isSupervisor(p.argv) {
+			continue
+		}
+		if err := syscall.Kill(p.pid, syscall.SIGTERM); err != nil {
+			continue
+		}
+		log.Printf("Stopped supervisor (PID %d)", p.pid)
+		stopped = append(stopped, p)
+	}
+	deadline := time.Now().Add(killGrace)
+	for _, p := range stopped {
+		for time.Now().Before(deadline) {
+			cur, err := readProcess(p.pid)
+			if err != nil || !sameStart(cur.start, p.start) || !isSupervisor(cur.argv) {
+				break
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
+	}
+	return len(stopped)
+}
+
+// PruneDuplicates ensures at most one forward per target host and port.
 // Keeps the first forward encountered, kills the rest.
 func PruneDuplicates() int {
 	forwards, err := ListForwards()
@@ -170,18 +303,19 @@ func PruneDuplicates() int {
 
 	byTarget := make(map[string][]Forward)
 	for _, f := range forwards {
-		byTarget[f.TargetHost] = append(byTarget[f.TargetHost], f)
+		target := fmt.Sprintf("%s:%d", f.TargetHost, f.TargetPort)
+		byTarget[target] = append(byTarget[target], f)
 	}
 
 	total := 0
-	for host, items := range byTarget {
+	for target, items := range byTarget {
 		if len(items) <= 1 {
 			continue
 		}
 		// Keep the first, kill the rest
 		for _, f := range items[1:] {
-			if killProcess(f.PID) {
-				log.Printf("Pruned duplicate SSM forward for %s (port %d, PID %d)", host, f.LocalPort, f.PID)
+			if killProcess(f) {
+				log.Printf("Pruned duplicate SSM forward for %s (port %d, PID %d)", target, f.LocalPort, f.PID)
 				total++
 			}
 		}
@@ -192,7 +326,7 @@ func PruneDuplicates() int {
 // CleanOldLogs removes SSM log files older than 24 hours.
 // Called at startup to prevent log accumulation.
 func CleanOldLogs() {
-	dir := ssmLogDir()
+	dir := LogDir()
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return
@@ -212,7 +346,8 @@ func CleanOldLogs() {
 	}
 }
 
-func ssmLogDir() string {
+// LogDir returns ~/.cache/kube-ssm-proxy/logs, creating it if needed.
+func LogDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = os.TempDir()
@@ -222,17 +357,54 @@ func ssmLogDir() string {
 	return dir
 }
 
-// killProcess sends SIGTERM, waits 2s, then SIGKILL if still alive.
-func killProcess(pid int) bool {
-	proc, err := os.FindProcess(pid)
+// killGrace is how long a forward gets to exit after SIGTERM before it is
+// killed. It exceeds the tunnel's shutdown, which can spend up to 10s
+// terminating its SSM session.
+const killGrace = 15 * time.Second
+
+// killProcess sends SIGTERM to f's process and waits up to killGrace for it
+// to exit, then sends SIGKILL if it is still running. Before each signal the
+// PID is checked to still be f's process (same start time, and a command
+// line for the same forward), so a PID reused by an unrelated process is
+// never signalled.
+func killProcess(f Forward) bool {
+	if err := f.checkProcess(); err != nil {
+		log.Printf("Not killing PID %d: %v", f.PID, err)
+		return false
+	}
+	proc, err := os.FindProcess(f.PID)
 	if err != nil {
 		return false
 	}
 	if err := proc.Signal(syscall.SIGTERM); err != nil {
 		return false
 	}
-	time.Sleep(2 * time.Second)
-	// Try SIGKILL in case it's still running
-	_ = proc.Signal(syscall.SIGKILL)
+	for deadline := time.Now().Add(killGrace); time.Now().Before(deadline); {
+		time.Sleep(250 * time.Millisecond)
+		if f.checkProcess() != nil {
+			return true
+		}
+	}
+	log.Printf("PID %d still running %s after SIGTERM; sending SIGKILL", f.PID, killGrace)
+	if f.checkProcess() == nil {
+		_ = proc.Signal(syscall.SIGKILL)
+	}
 	return true
 }
+
+// checkProcess verifies that f.PID still runs f's forward.
+func (f Forward) checkProcess() error {
+	p, err := readProcess(f.PID)
+	if err != nil {
+		return fmt.Errorf("process gone: %w", err)
+	}
+	if !sameStart(p.start, f.ProcessStart) {
+		return fmt.Errorf("PID reused: started %s, forward's process started %s",
+			p.start.Format(time.DateTime), f.ProcessStart.Format(time.DateTime))
+	}
+	cur, ok := parseProcess(p)
+	if !ok || cur.LocalPort != f.LocalPort || cur.TargetHost != f.TargetHost || cur.BastionID != f.BastionID {
+		return fmt.Errorf("command line no longer matches the forward on port %d", f.LocalPort)
+	}
+	return nil
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"kube-ssm-proxy/internal/aws"
 	"kube-ssm-proxy/internal/config"
 	"kube-ssm-proxy/internal/kubeconfig"
+	"kube-ssm-proxy/internal/partition"
 	"kube-ssm-proxy/internal/selector"
 	"kube-ssm-proxy/internal/ssm"
 )
@@ -27,6 +31,28 @@ const (
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to clusters.yaml (overrides $"+config.EnvConfigPath+" and discovery)")
+	flag.Usage = usage
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "":
+	case "validate":
+		os.Exit(runValidate(*configPath, flag.Args()[1:]))
+	case "discover":
+		os.Exit(runDiscover(*configPath, flag.Args()[1:]))
+	case "config":
+		os.Exit(runConfig(*configPath, flag.Args()[1:]))
+	case "supervise":
+		os.Exit(runSupervise(*configPath, flag.Args()[1:]))
+	case "tunnel": // internal: run by ssm.StartForward
+		os.Exit(runTunnel(flag.Args()[1:]))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
 	// Signal handling
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
@@ -39,12 +65,26 @@ func main() {
 	fmt.Printf("\n%sPress Escape or Ctrl+C in the selector to exit.%s\n", dim, reset)
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%sFailed to load configuration: %v%s\n", red, err, reset)
 		os.Exit(1)
 	}
-	log.Printf("Loaded %d clusters", len(cfg.Clusters))
+	log.Printf("Loaded %d clusters from %s", len(cfg.Clusters), cfg.Path)
+	for _, w := range cfg.Warnings {
+		log.Print(w)
+	}
+	for _, f := range cfg.Outdated {
+		log.Printf("Config %s uses an older schema version; run `kube-ssm-proxy config migrate` to update it", f)
+	}
+	for _, in := range cfg.Interpolated {
+		log.Printf("Resolved %s = %q (from %q in %s)", in.Path, in.Value, in.Raw, in.File)
+	}
+	if len(cfg.Files) > 1 {
+		for _, c := range cfg.Clusters {
+			log.Printf("Cluster %s: %s", c.Name, c.SourceSummary())
+		}
+	}
 
 	// Clean up old SSM log files
 	ssm.CleanOldLogs()
@@ -52,12 +92,14 @@ func main() {
 	// Prune duplicate SSM sessions
 	if pruned := ssm.PruneDuplicates(); pruned > 0 {
 		log.Printf("Pruned %d duplicate SSM sessions at startup", pruned)
+		stopIdleBastions()
 	}
+	adoptForwards(cfg.Clusters)
 
 	// Display existing port forwards and select
 	var selected *config.ClusterConfig
 	for {
-		displayForwards()
+		displayForwards(cfg.Clusters)
 
 		var killAll bool
 		var err error
@@ -69,8 +111,13 @@ func main() {
 
 		if killAll {
 			fmt.Printf("\n%sKilling all SSM port forwarding sessions...%s\n", red, reset)
+			// Supervisors would restart the forwards.
+			if n := ssm.StopSupervisors(); n > 0 {
+				fmt.Printf("%sStopped %d supervisor(s)%s\n", yellow, n, reset)
+			}
 			ssm.StopAll()
 			kubeconfig.MarkAllLocalhostInactive()
+			stopIdleBastions()
 			continue
 		}
 
@@ -85,9 +132,13 @@ func main() {
 	fmt.Printf("\n%sConnecting to %s...%s\n", blue, selected.Name, reset)
 
 	if *selected.UseBastion {
-		connectSSM(selected, cfg.SSO)
+		err = connectSSM(selected, cfg)
 	} else {
-		connectDirect(selected, cfg.SSO)
+		err = connectDirect(selected, cfg.SSO)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%sFailed to connect to %s: %v%s\n", red, selected.Name, err, reset)
+		os.Exit(1)
 	}
 
 	// Check for headless exit
@@ -97,105 +148,355 @@ func main() {
 	}
 
 	// Display final state
-	displayForwards()
+	displayForwards(cfg.Clusters)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: kube-ssm-proxy [--config PATH] [command]
+
+With no command, select a cluster interactively and connect to it.
+
+Commands:
+  validate [--schema] [--skip-profiles]   check the config file and report problems
+  discover --profiles P --regions R [--write]
+                                          generate cluster entries from EKS
+  config migrate [--dry-run]              upgrade the config file to the current schema version
+  supervise [--interval D] CLUSTER...     connect clusters and restart their forwards when they exit
+
+Flags:
+`)
+	flag.PrintDefaults()
 }
 
 // connectSSM handles the SSM port-forward path.
-func connectSSM(cluster *config.ClusterConfig, sso config.SSOConfig) {
+func connectSSM(cluster *config.ClusterConfig, cfg config.Config) error {
+	sso := cfg.SSO
+	portRange := ssm.PortRange{Min: cfg.PortRange.Min, Max: cfg.PortRange.Max}
+
 	// Fast path: check if there's already a forward for this cluster
 	forwards, _ := ssm.ListForwards()
 	for _, f := range forwards {
-		ctx := kubeconfig.ContextForPort(f.LocalPort)
-		if ctx == cluster.Name {
+		if f.Cluster == cluster.Name && f.Name == "" {
 			log.Printf("Reusing existing forward on port %d", f.LocalPort)
-			if err := kubeconfig.SwitchContext(ctx); err != nil {
-				fmt.Fprintf(os.Stderr, "%sFailed to switch context: %v%s\n", red, err, reset)
-				os.Exit(1)
+			if err := kubeconfig.SwitchContext(cluster.Name); err != nil {
+				return fmt.Errorf("switch context: %w", err)
 			}
 			fmt.Printf("%sConnection established to %s (reused port %d)%s\n", green, cluster.Name, f.LocalPort, reset)
-			return
+			startExtraForwards(cluster, "", cfg)
+			return nil
 		}
 	}
 
 	// Authenticate
 	auth, err := aws.Authenticate(cluster.Profile, sso.StartURL, sso.Region)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "\n%s%v%s\n", red, err, reset)
-		os.Exit(1)
+		return err
 	}
 
-	// Get EKS endpoint
-	endpoint, err := aws.DescribeCluster(cluster.Profile, cluster.Region, cluster.ClusterName)
+	target := clusterTarget(cluster)
+
+	// Get EKS endpoint and VPC
+	info, err := aws.DescribeCluster(target, cluster.ClusterName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%sFailed to get cluster endpoint: %v%s\n", red, err, reset)
-		os.Exit(1)
+		return fmt.Errorf("get cluster endpoint: %w", err)
+	}
+
+	// Check the bastion's credentials when it lives elsewhere
+	bastion := bastionTarget(cluster)
+	if err := checkBastionAccount(cluster, bastion, auth, sso); err != nil {
+		return err
 	}
 
 	// Find bastion
-	bastionID, err := aws.FindBastion(cluster.Profile, cluster.Region, cluster.BastionTag)
+	bastionID, err := findBastion(cluster, info, cfg.FzfHeight, true)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%sFailed to find bastion: %v%s\n", red, err, reset)
-		os.Exit(1)
+		return fmt.Errorf("find bastion: %w", err)
 	}
 
-	// Start port forward (skip ports already in kubeconfig), retry up to 3 times
-	const maxRetries = 3
-	var port int
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		port, err = ssm.StartForward(cluster.Name, bastionID, endpoint, cluster.Profile, cluster.Region,
-			kubeconfig.PortsInUse(), kubeconfig.MarkPortInactive, attempt, maxRetries)
-		if err == nil {
-			break
-		}
-		if attempt < maxRetries {
-			log.Printf("SSM forward attempt %d/%d failed: %v", attempt, maxRetries, err)
-			fmt.Fprintf(os.Stderr, "%s⚠ SSM connection failed (attempt %d/%d), retrying in 5s...%s\n", yellow, attempt, maxRetries, reset)
-			time.Sleep(5 * time.Second)
-		} else {
-			fmt.Fprintf(os.Stderr, "%sFailed to start port forward after %d attempts: %v%s\n", red, maxRetries, err, reset)
-			os.Exit(1)
+	// Custom session document
+	if cluster.SSMDocument != "" {
+		if err := aws.CheckDocument(bastion, cluster.SSMDocument, cluster.SSMDocumentVersion); err != nil {
+			return fmt.Errorf("check SSM document: %w", err)
 		}
 	}
 
+	// Start port forward on the cluster's pinned or name-derived port (skipping
+	// ports other clusters hold in kubeconfig), retry up to 3 times
+	port, err := startForwardWithRetry(ssm.ForwardOptions{
+		ClusterName:   cluster.Name,
+		BastionID:     bastionID,
+		TargetHost:    info.Endpoint,
+		LocalPort:     cluster.LocalPort,
+		Profile:       bastion.Profile,
+		Region:        bastion.Region,
+		Document:      cluster.SSMDocument,
+		Parameters:    cluster.SSMDocumentParameters,
+		PortSeed:      cluster.Name,
+		PortRange:     portRange,
+		ReservedPorts: kubeconfig.PortsInUse(cluster.Name),
+		MarkInactive:  kubeconfig.MarkPortInactive,
+		Role:          bastion.Role,
+	})
+	if err != nil {
+		return fmt.Errorf("start port forward: %w", err)
+	}
+
 	// Update kubeconfig
 	if err := kubeconfig.SetClusterSSM(
 		cluster.Name, cluster.ClusterName, cluster.Region,
-		cluster.Profile, auth.AccountID, port,
+		cluster.Profile, clusterAccount(cluster, auth), port, credentialProvider(cluster),
 	); err != nil {
-		fmt.Fprintf(os.Stderr, "%sFailed to update kubeconfig: %v%s\n", red, err, reset)
-		os.Exit(1)
+		return fmt.Errorf("update kubeconfig: %w", err)
 	}
 
 	fmt.Printf("%sConnection established to %s (port %d)%s\n", green, cluster.Name, port, reset)
+	startExtraForwards(cluster, bastionID, cfg)
+	return nil
+}
+
+// startForwardWithRetry starts an SSM forward, retrying up to 3 times with
+// a 5s pause since sessions often fail transiently (e.g. TargetNotConnected).
+func startForwardWithRetry(opts ssm.ForwardOptions) (int, error) {
+	const maxRetries = 3
+	for attempt := 1; ; attempt++ {
+		port, err := ssm.StartForward(opts, attempt, maxRetries)
+		if err == nil {
+			return port, nil
+		}
+		if attempt == maxRetries {
+			return 0, fmt.Errorf("after %d attempts: %w", maxRetries, err)
+		}
+		log.Printf("SSM forward attempt %d/%d failed: %v", attempt, maxRetries, err)
+		fmt.Fprintf(os.Stderr, "%s⚠ SSM connection failed (attempt %d/%d), retrying in 5s...%s\n", yellow, attempt, maxRetries, reset)
+		time.Sleep(5 * time.Second)
+	}
 }
 
 // connectDirect handles the direct-connect path (no SSM).
-func connectDirect(cluster *config.ClusterConfig, sso config.SSOConfig) {
+func connectDirect(cluster *config.ClusterConfig, sso config.SSOConfig) error {
 	auth, err := aws.Authenticate(cluster.Profile, sso.StartURL, sso.Region)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "\n%s%v%s\n", red, err, reset)
-		os.Exit(1)
+		return err
 	}
 
-	endpoint, err := aws.DescribeCluster(cluster.Profile, cluster.Region, cluster.ClusterName)
+	info, err := aws.DescribeCluster(clusterTarget(cluster), cluster.ClusterName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%sFailed to get cluster endpoint: %v%s\n", red, err, reset)
-		os.Exit(1)
+		return fmt.Errorf("get cluster endpoint: %w", err)
 	}
 
 	if err := kubeconfig.SetClusterDirect(
 		cluster.Name, cluster.ClusterName, cluster.Region,
-		cluster.Profile, auth.AccountID, endpoint,
+		cluster.Profile, clusterAccount(cluster, auth), info.Endpoint, credentialProvider(cluster),
 	); err != nil {
-		fmt.Fprintf(os.Stderr, "%sFailed to update kubeconfig: %v%s\n", red, err, reset)
-		os.Exit(1)
+		return fmt.Errorf("update kubeconfig: %w", err)
 	}
 
 	fmt.Printf("%sConnection established to %s (direct)%s\n", green, cluster.Name, reset)
+	return nil
+}
+
+// credentialProvider converts the cluster's credential settings for the
+// kubeconfig package.
+func credentialProvider(cluster *config.ClusterConfig) kubeconfig.CredentialProvider {
+	p := cluster.CredentialProvider
+	return kubeconfig.CredentialProvider{
+		Type:        p.Type,
+		Command:     p.Command,
+		Args:        p.Args,
+		Env:         p.Env,
+		RoleARN:     cluster.AssumeRoleARN,
+		ExternalID:  cluster.ExternalID,
+		SessionName: cluster.RoleSessionName,
+	}
+}
+
+// clusterTarget returns the AWS credentials/region used for the cluster's
+// own API calls (EKS and forward resource lookups). The bastion may differ;
+// see bastionTarget.
+func clusterTarget(cluster *config.ClusterConfig) aws.Target {
+	return aws.Target{
+		Profile: cluster.Profile,
+		Region:  cluster.Region,
+		Role: aws.AssumeRole{
+			ARN:         cluster.AssumeRoleARN,
+			ExternalID:  cluster.ExternalID,
+			SessionName: cluster.RoleSessionName,
+		},
+	}
+}
+
+// findBastion looks up the cluster's bastion. A stopped one is started when
+// auto_start_bastion is set or the user agrees, and recorded for stopping
+// later when auto_stop_bastion is set. Unless interactive, nothing prompts:
+// a stopped bastion without auto_start_bastion, or several matches under the
+// pick strategy, is an error.
+func findBastion(cluster *config.ClusterConfig, info aws.ClusterInfo, fzfHeight string, interactive bool) (string, error) {
+	target := bastionTarget(cluster)
+	sel := bastionSelector(cluster, info, fzfHeight)
+	if !interactive {
+		sel.Pick = func(candidates []aws.Bastion) (aws.Bastion, error) {
+			return aws.Bastion{}, fmt.Errorf("bastion_strategy %s needs a terminal to choose", aws.StrategyPick)
+		}
+	}
+	id, err := aws.FindBastion(target, sel)
+	var stopped *aws.StoppedBastionError
+	if !errors.As(err, &stopped) {
+		return id, err
+	}
+
+	b := stopped.Stopped[0]
+	if !cluster.AutoStartBastion && (!interactive || !selector.Confirm(fmt.Sprintf("Bastion %s is stopped. Start it?", b))) {
+		return "", err
+	}
+	fmt.Printf("%sStarting bastion %s...%s\n", yellow, b.InstanceID, reset)
+	if err := aws.StartBastion(target, b.InstanceID); err != nil {
+		return "", err
+	}
+	if cluster.AutoStopBastion {
+		if err := aws.RecordStartedBastion(aws.StartedBastion{
+			InstanceID: b.InstanceID, Target: target, StartedAt: time.Now(),
+		}); err != nil {
+			log.Printf("Warning: cannot record started bastion %s: %v", b.InstanceID, err)
+		}
+	}
+	return aws.FindBastion(target, sel)
+}
+
+// stopIdleBastions stops bastions started with auto_stop_bastion that no
+// longer carry any forward.
+func stopIdleBastions() {
+	started := aws.StartedBastions()
+	if len(started) == 0 {
+		return
+	}
+	inUse, err := ssm.BastionsInUse()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	for _, b := range started {
+		if inUse[b.InstanceID] {
+			continue
+		}
+		fmt.Printf("%sStopping bastion %s (no forwards left)...%s\n", yellow, b.InstanceID, reset)
+		if err := aws.StopBastion(b.Target, b.InstanceID); err != nil {
+			fmt.Fprintf(os.Stderr, "%s⚠ %v%s\n", yellow, err, reset)
+			continue
+		}
+		if err := aws.ForgetStartedBastion(b.InstanceID); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// bastionSelector converts the cluster's (validated) bastion settings into
+// an EC2 instance query, scoped to the cluster's VPC or subnets according to
+// bastion_scope. fzfHeight is used by the pick strategy.
+func bastionSelector(cluster *config.ClusterConfig, info aws.ClusterInfo, fzfHeight string) aws.BastionSelector {
+	sel := configuredBastion(cluster)
+	switch cluster.BastionScope {
+	case "vpc":
+		sel = sel.InVPC(info.VpcID)
+	case "subnets":
+		sel = sel.InVPC(info.VpcID).InSubnets(info.SubnetIDs)
+	}
+	sel.Strategy = cluster.BastionStrategy
+	if !cluster.RemoteBastion() {
+		sel.ControlPlaneSubnets = info.SubnetIDs
+	}
+	sel.Pick = func(candidates []aws.Bastion) (aws.Bastion, error) {
+		options := make([]string, len(candidates))
+		for i, b := range candidates {
+			options[i] = b.String()
+		}
+		i, err := selector.Choose("Bastion for "+cluster.Name, options, fzfHeight)
+		if err != nil {
+			return aws.Bastion{}, err
+		}
+		if i < 0 {
+			return aws.Bastion{}, fmt.Errorf("no bastion chosen")
+		}
+		return candidates[i], nil
+	}
+	return sel
+}
+
+func configuredBastion(cluster *config.ClusterConfig) aws.BastionSelector {
+	b := cluster.Bastion
+	if b.InstanceID != "" {
+		return aws.BastionSelector{InstanceID: b.InstanceID}
+	}
+	if b.Name != "" {
+		return aws.BastionSelector{Filters: []aws.BastionFilter{aws.TagFilter("Name", b.Name)}}
+	}
+	var sel aws.BastionSelector
+	for _, f := range b.Filters {
+		var filter aws.BastionFilter
+		switch {
+		case f.Tag != "":
+			key, value, _ := strings.Cut(f.Tag, "=")
+			filter = aws.TagFilter(key, value)
+		case f.VPCID != "":
+			filter = aws.BastionFilter{Name: "vpc-id", Values: []string{f.VPCID}}
+		case f.SubnetID != "":
+			filter = aws.BastionFilter{Name: "subnet-id", Values: []string{f.SubnetID}}
+		case f.AvailabilityZone != "":
+			filter = aws.BastionFilter{Name: "availability-zone", Values: []string{f.AvailabilityZone}}
+		}
+		sel.Filters = append(sel.Filters, filter)
+	}
+	return sel
+}
+
+// bastionTarget returns the AWS credentials/region for the bastion's EC2
+// and SSM calls: the bastion_* overrides, falling back to the cluster's.
+// The cluster's role is kept unless a different profile or account is
+// configured for the bastion.
+func bastionTarget(cluster *config.ClusterConfig) aws.Target {
+	t := clusterTarget(cluster)
+	t.Region = cluster.EffectiveBastionRegion()
+	if cluster.BastionProfile != "" && cluster.BastionProfile != cluster.Profile {
+		t.Profile = cluster.BastionProfile
+		t.Role = aws.AssumeRole{}
+	}
+	if cluster.BastionAccount != "" && cluster.BastionAccount != partition.AccountFromARN(t.Role.ARN) {
+		t.Role = aws.AssumeRole{}
+	}
+	return t
+}
+
+// checkBastionAccount authenticates the bastion's profile when it differs
+// from the cluster's, and verifies bastion_account if set.
+func checkBastionAccount(cluster *config.ClusterConfig, bastion aws.Target, auth *aws.AuthInfo, sso config.SSOConfig) error {
+	account := auth.AccountID
+	if bastion.Profile != cluster.Profile {
+		bastionAuth, err := aws.Authenticate(bastion.Profile, sso.StartURL, sso.Region)
+		if err != nil {
+			return err
+		}
+		account = bastionAuth.AccountID
+	}
+	if acct := partition.AccountFromARN(bastion.Role.ARN); acct != "" {
+		account = acct
+	}
+	if cluster.BastionAccount != "" && cluster.BastionAccount != account {
+		return fmt.Errorf("bastion_account is %s but profile %s is in account %s", cluster.BastionAccount, bastion.Profile, account)
+	}
+	return nil
 }
 
-// displayForwards prints existing SSM port-forwarding sessions.
-func displayForwards() {
+// clusterAccount returns the account the cluster lives in: the assumed
+// role's account if one is configured, else the profile's.
+func clusterAccount(cluster *config.ClusterConfig, auth *aws.AuthInfo) string {
+	if acct := partition.AccountFromARN(cluster.AssumeRoleARN); acct != "" {
+		return acct
+	}
+	return auth.AccountID
+}
+
+// displayForwards prints existing SSM port-forwarding sessions, labelled
+// with the kubectl context (API tunnels) or configured forward they serve.
+func displayForwards(clusters []config.ClusterConfig) {
 	forwards, err := ssm.ListForwards()
 	if err != nil {
 		log.Printf("Warning: %v", err)
@@ -209,19 +510,31 @@ func displayForwards() {
 
 	fmt.Printf("\n%s%sExisting SSM Port Forwards:%s\n", bold, reset, reset)
 	for _, f := range forwards {
-		ctx := kubeconfig.ContextForPort(f.LocalPort)
-		if ctx != "" {
-			fmt.Printf("  %s●%s Port %d [%s] -> %s (PID: %d)\n",
-				green, reset, f.LocalPort, ctx, f.TargetHost, f.PID)
+		label := forwardLabel(f)
+		if label == "" {
+			label = extraForwardLabel(clusters, f)
+		}
+		if label != "" {
+			fmt.Printf("  %s●%s Port %d [%s] -> %s:%d (PID: %d)\n",
+				green, reset, f.LocalPort, label, f.TargetHost, f.TargetPort, f.PID)
 		} else {
-			fmt.Printf("  %s●%s Port %d -> %s (PID: %d)\n",
-				green, reset, f.LocalPort, f.TargetHost, f.PID)
+			fmt.Printf("  %s●%s Port %d -> %s:%d (PID: %d)\n",
+				green, reset, f.LocalPort, f.TargetHost, f.TargetPort, f.PID)
 		}
 	}
 }
 
+// forwardLabel returns the cluster (API tunnels) or cluster/forward the
+// registry records for f, or "" if it has no entry.
+func forwardLabel(f ssm.Forward) string {
+	if f.Name != "" {
+		return f.Cluster + "/" + f.Name
+	}
+	return f.Cluster
+}
+
 // activeClusterNames returns the set of cluster names that have an active
-// SSM port forward, by matching forward ports to kubeconfig entries.
+// SSM API tunnel, according to the forward registry.
 func activeClusterNames() map[string]bool {
 	names := make(map[string]bool)
 	forwards, err := ssm.ListForwards()
@@ -229,10 +542,38 @@ func activeClusterNames() map[string]bool {
 		return names
 	}
 	for _, f := range forwards {
-		ctx := kubeconfig.ContextForPort(f.LocalPort)
-		if ctx != "" {
-			names[ctx] = true
+		if f.Cluster != "" && f.Name == "" {
+			names[f.Cluster] = true
 		}
 	}
 	return names
 }
+
+// adoptForwards records running forwards the registry does not know, such
+// as ones started before it existed, by the kubeconfig context pointing at
+// their port or the extra forward they match. This is the only place ports
+// are mapped back to clusters through kubeconfig.
+func adoptForwards(clusters []config.ClusterConfig) {
+	forwards, err := ssm.ListForwards()
+	if err != nil {
+		return
+	}
+	for _, f := range forwards {
+		if f.Cluster != "" {
+			continue
+		}
+		label := kubeconfig.ContextForPort(f.LocalPort)
+		if label == "" {
+			label = extraForwardLabel(clusters, f)
+		}
+		if label == "" {
+			continue
+		}
+		f.Cluster, f.Name, _ = strings.Cut(label, "/")
+		if err := ssm.Register(f); err != nil {
+			log.Printf("Warning: cannot record forward on port %d: %v", f.LocalPort, err)
+			continue
+		}
+		log.Printf("Recorded existing forward on port %d as %s", f.LocalPort, label)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kube-ssm-proxy/internal/config"
+)
+
+// runConfig implements `kube-ssm-proxy config <subcommand>`. The only
+// subcommand is migrate, which upgrades the config file and the files it
+// includes to the current schema version in place.
+func runConfig(configPath string, args []string) int {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprintf(os.Stderr, "usage: kube-ssm-proxy config migrate [--dry-run]\n")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the migrated files instead of writing them")
+	fs.Parse(args[1:])
+
+	path, err := config.ResolvePath(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, reset)
+		return 1
+	}
+	files, err := config.IncludedFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, reset)
+		return 1
+	}
+
+	for _, f := range files {
+		from, steps, out, err := config.MigrateFile(f, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, reset)
+			return 1
+		}
+		if *dryRun {
+			if len(files) > 1 {
+				fmt.Printf("# %s\n", f)
+			}
+			fmt.Print(string(out))
+			continue
+		}
+		if len(steps) == 0 {
+			fmt.Printf("%s%s is already at version %d%s\n", green, f, config.CurrentVersion, reset)
+			continue
+		}
+		for _, s := range steps {
+			fmt.Printf("  %s\n", s)
+		}
+		if from == config.CurrentVersion {
+			fmt.Printf("%sSet %s to version %d%s\n", green, f, from, reset)
+			continue
+		}
+		fmt.Printf("%sMigrated %s from version %d to %d%s\n", green, f, from, config.CurrentVersion, reset)
+	}
+	return 0
+}
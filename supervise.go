@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"kube-ssm-proxy/internal/config"
+	"kube-ssm-proxy/internal/ssm"
+)
+
+// Restart backoff for supervised forwards.
+const (
+	minRestartDelay = 5 * time.Second
+	maxRestartDelay = 5 * time.Minute
+	// stableAfter is how long a forward must have run for its next exit to
+	// restart it after minRestartDelay again instead of the grown delay.
+	stableAfter = 5 * time.Minute
+)
+
+// runSupervise implements `kube-ssm-proxy supervise CLUSTER...`. It connects
+// each named cluster as the selector would, then stays in the foreground
+// watching the clusters' forwards (the API tunnel and extra forwards), each
+// on its own so a slow restart does not hold up the others. A forward whose
+// process exits is restarted on the same local port, so kubeconfig entries
+// stay correct, with growing delays for one that keeps exiting. Restarts
+// never prompt. They are logged to a supervise_*.log file next to the
+// session logs. The forwards keep running when supervise exits, which
+// "Kill all" makes it do before killing them.
+func runSupervise(configPath string, args []string) int {
+	fs := flag.NewFlagSet("supervise", flag.ExitOnError)
+	interval := fs.Duration("interval", 10*time.Second, "how often forwards are checked")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%ssupervise requires at least one cluster name%s\n", red, reset)
+		return 2
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to load configuration: %v%s\n", red, err, reset)
+		return 1
+	}
+	for _, w := range cfg.Warnings {
+		log.Print(w)
+	}
+	var clusters []*config.ClusterConfig
+	for _, name := range fs.Args() {
+		c := findCluster(cfg.Clusters, name)
+		if c == nil {
+			fmt.Fprintf(os.Stderr, "%sunknown cluster %q%s\n", red, name, reset)
+			return 2
+		}
+		if !*c.UseBastion {
+			fmt.Fprintf(os.Stderr, "%scluster %s connects directly; there is no forward to supervise%s\n", red, name, reset)
+			return 2
+		}
+		clusters = append(clusters, c)
+	}
+
+	logPath := filepath.Join(ssm.LogDir(), fmt.Sprintf("supervise_%s.log", time.Now().Format("2006-01-02_15-04-05")))
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%screate supervisor log: %v%s\n", red, err, reset)
+		return 1
+	}
+	defer logFile.Close()
+	log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	log.Printf("Supervising %s (log: %s)", strings.Join(fs.Args(), ", "), logPath)
+
+	for _, c := range clusters {
+		fmt.Printf("\n%sConnecting to %s...%s\n", blue, c.Name, reset)
+		if err := connectSSM(c, cfg); err != nil {
+			log.Printf("Failed to connect to %s: %v", c.Name, err)
+			return 1
+		}
+	}
+
+	adoptForwards(cfg.Clusters)
+	watched := supervisedForwards(clusters)
+	if len(watched) == 0 {
+		log.Printf("No forwards found to supervise")
+		return 1
+	}
+	for _, w := range watched {
+		log.Printf("Watching %s on port %d (PID %d)", w.label(), w.fwd.LocalPort, w.fwd.PID)
+		go w.watch(*interval, cfg)
+	}
+
+	// Forwards are detached, so exiting leaves them running.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	log.Printf("Supervisor stopped; forwards keep running")
+	return 0
+}
+
+func findCluster(clusters []config.ClusterConfig, name string) *config.ClusterConfig {
+	for i := range clusters {
+		if clusters[i].Name == name {
+			return &clusters[i]
+		}
+	}
+	return nil
+}
+
+// supervisedForwards returns the running forwards the registry attributes
+// to clusters.
+func supervisedForwards(clusters []*config.ClusterConfig) []*supervisedForward {
+	forwards, err := ssm.ListForwards()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	var watched []*supervisedForward
+	for _, f := range forwards {
+		for _, c := range clusters {
+			if f.Cluster == c.Name {
+				watched = append(watched, &supervisedForward{cluster: c, name: f.Name, fwd: f, started: time.Now()})
+			}
+		}
+	}
+	return watched
+}
+
+// supervisedForward is one forward kept alive by supervise.
+type supervisedForward struct {
+	cluster *config.ClusterConfig
+	name    string      // extra forward name; empty for the API tunnel
+	fwd     ssm.Forward // last process seen serving the port
+
+	started   time.Time     // when the current process was seen or started
+	down      bool          // process gone, restart pending
+	delay     time.Duration // current restart delay
+	nextStart time.Time
+	failures  int // consecutive failed restarts
+}
+
+func (w *supervisedForward) label() string {
+	if w.name == "" {
+		return w.cluster.Name
+	}
+	return w.cluster.Name + "/" + w.name
+}
+
+// watch checks the forward every interval.
+func (w *supervisedForward) watch(interval time.Duration, cfg config.Config) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		forwards, err := ssm.ListForwards()
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		running := make(map[int]ssm.Forward)
+		for _, f := range forwards {
+			running[f.LocalPort] = f
+		}
+		w.check(running, cfg)
+	}
+}
+
+// check restarts the forward if nothing serves its port any more and its
+// restart delay has passed. A new process found on the port (e.g. started
+// by an interactive run) is adopted.
+func (w *supervisedForward) check(running map[int]ssm.Forward, cfg config.Config) {
+	port := w.fwd.LocalPort
+	if f, ok := running[port]; ok {
+		if w.fwd.PID != 0 && f.PID != w.fwd.PID {
+			log.Printf("%s on port %d is now served by PID %d", w.label(), port, f.PID)
+			w.started = time.Now()
+		}
+		w.fwd = f
+		w.down = false
+		return
+	}
+
+	now := time.Now()
+	if !w.down {
+		w.down = true
+		if w.delay == 0 || now.Sub(w.started) >= stableAfter {
+			w.delay = minRestartDelay
+		} else {
+			w.delay = min(w.delay*2, maxRestartDelay)
+		}
+		w.nextStart = now.Add(w.delay)
+		log.Printf("%s on port %d (PID %d) exited; restarting in %s", w.label(), port, w.fwd.PID, w.delay)
+	}
+	if now.Before(w.nextStart) {
+		return
+	}
+
+	log.Printf("Restarting %s on port %d", w.label(), port)
+	if err := w.restart(cfg); err != nil {
+		w.failures++
+		w.delay = min(w.delay*2, maxRestartDelay)
+		w.nextStart = time.Now().Add(w.delay)
+		log.Printf("Restart of %s failed: %v; retrying in %s", w.label(), err, w.delay)
+		return
+	}
+	w.failures = 0
+	w.down = false
+	w.started = time.Now()
+	w.fwd.PID = 0 // adopted from the next scan
+	log.Printf("Restarted %s on port %d", w.label(), port)
+}
+
+// restart starts the forward again on its old port, through the same
+// bastion unless a previous restart failed, in which case the bastion is
+// looked up again (without prompting) in case it was replaced. A bastion
+// left behind that way is stopped if this tool started it and nothing else
+// uses it.
+func (w *supervisedForward) restart(cfg config.Config) error {
+	c := w.cluster
+	previous := w.fwd.BastionID
+	if w.failures > 0 {
+		id, err := findClusterBastion(c, cfg.FzfHeight, false)
+		if err != nil {
+			return fmt.Errorf("find bastion: %w", err)
+		}
+		w.fwd.BastionID = id
+	}
+	bastion := bastionTarget(c)
+	_, err := ssm.StartForward(ssm.ForwardOptions{
+		ClusterName: c.Name,
+		Name:        w.name,
+		BastionID:   w.fwd.BastionID,
+		TargetHost:  w.fwd.TargetHost,
+		TargetPort:  w.fwd.TargetPort,
+		LocalPort:   w.fwd.LocalPort,
+		Profile:     bastion.Profile,
+		Region:      bastion.Region,
+		Document:    c.SSMDocument,
+		Parameters:  c.SSMDocumentParameters,
+		Role:        bastion.Role,
+	}, 1, 1)
+	if err != nil {
+		return err
+	}
+	if w.fwd.BastionID != previous {
+		stopIdleBastions()
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"kube-ssm-proxy/internal/aws"
+	"kube-ssm-proxy/internal/session"
+	"kube-ssm-proxy/internal/ssm"
+)
+
+// runTunnel implements the internal `kube-ssm-proxy tunnel` command, which
+// ssm.StartForward runs as a detached process in place of
+// `aws ssm start-session`. It starts the SSM session, listens on the
+// localPortNumber parameter and forwards connections over the session's data
+// channel, starting a fresh session whenever the channel is lost, until the
+// process is signalled or reconnecting gives up. Its flags mirror the aws
+// CLI's so running tunnels are recognised the same way.
+func runTunnel(args []string) int {
+	fs := flag.NewFlagSet("tunnel", flag.ExitOnError)
+	target := fs.String("target", "", "bastion instance ID (required)")
+	document := fs.String("document-name", ssm.DefaultDocument, "SSM session document")
+	parameters := fs.String("parameters", "", "document parameters as k=v,k=v; must include localPortNumber")
+	region := fs.String("region", "", "AWS region of the bastion")
+	profile := fs.String("profile", "", "AWS profile")
+	roleARN := fs.String("role-arn", "", "role to assume on top of the profile")
+	sessionName := fs.String("role-session-name", "", "session name for --role-arn")
+	fs.Parse(args)
+	// The external ID comes through the environment so it stays out of ps.
+	externalID := os.Getenv(ssm.ExternalIDEnv)
+	os.Unsetenv(ssm.ExternalIDEnv)
+
+	// stdout and stderr are the session log file set up by StartForward.
+	log.SetFlags(0)
+	log.SetOutput(ssm.NewTimestampWriter(os.Stderr))
+
+	params, err := parseParameters(*parameters)
+	if err != nil {
+		log.Printf("Invalid --parameters: %v", err)
+		return 2
+	}
+	localPort, err := strconv.Atoi(firstParam(params, "localPortNumber"))
+	if *target == "" || err != nil {
+		log.Printf("tunnel requires --target and a localPortNumber parameter")
+		return 2
+	}
+	t := aws.Target{
+		Profile: *profile,
+		Region:  *region,
+		Role:    aws.AssumeRole{ARN: *roleARN, ExternalID: externalID, SessionName: *sessionName},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// current is the live session, terminated when the tunnel exits and
+	// replaced whenever the data channel is lost.
+	var (
+		mu      sync.Mutex
+		current string
+	)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if current != "" {
+			terminateSession(t, current)
+		}
+	}()
+	connect := func(ctx context.Context) (*session.Channel, error) {
+		sess, err := aws.StartSession(ctx, t, *target, *document, params)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Started session %s on %s", sess.ID, *target)
+		ch, err := session.Open(ctx, session.Options{
+			SessionID: sess.ID,
+			StreamURL: sess.StreamURL,
+			Token:     sess.Token,
+			TargetID:  *target,
+			GenerateDataKey: func(ctx context.Context, keyID string, encCtx map[string]string) ([]byte, []byte, error) {
+				return aws.GenerateDataKey(ctx, t, keyID, encCtx)
+			},
+		})
+		if err != nil {
+			terminateSession(t, sess.ID)
+			return nil, fmt.Errorf("session %s: %w", sess.ID, err)
+		}
+		log.Printf("Session %s connected (agent %s, encrypted: %t)", sess.ID, ch.AgentVersion(), ch.Encrypted())
+		mu.Lock()
+		defer mu.Unlock()
+		if current != "" {
+			terminateSession(t, current)
+		}
+		current = sess.ID
+		return ch, nil
+	}
+
+	ch, err := connect(ctx)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", localPort))
+	if err != nil {
+		ch.Close()
+		log.Printf("%v", err)
+		return 1
+	}
+	log.Printf("Port %d opened", localPort)
+
+	// The listener outlives any one session: if the data channel drops,
+	// Serve starts a new session and connections keep working.
+	err = session.Serve(ctx, l, ch, connect)
+	if ctx.Err() != nil {
+		log.Printf("Received signal, closing tunnel")
+		return 0
+	}
+	log.Printf("Tunnel ended: %v", err)
+	// Nobody kills a tunnel that gives up, so it checks for itself whether
+	// its bastion is now idle.
+	stopIdleBastions()
+	return 1
+}
+
+// terminateSession ends the session on the SSM side. It gets a fresh
+// context since the tunnel's own may already be cancelled.
+func terminateSession(t aws.Target, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := aws.TerminateSession(ctx, t, id); err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	log.Printf("Terminated session %s", id)
+}
+
+// parseParameters parses the aws CLI's shorthand k=v,k=v form.
+func parseParameters(s string) (map[string][]string, error) {
+	params := make(map[string][]string)
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		params[k] = append(params[k], v)
+	}
+	return params, nil
+}
+
+func firstParam(params map[string][]string, key string) string {
+	if v := params[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kube-ssm-proxy/internal/aws"
+	"kube-ssm-proxy/internal/config"
+)
+
+// runValidate implements `kube-ssm-proxy validate`. It reports every
+// problem in the config with file:line:column positions and returns the
+// process exit code. With --schema it prints the JSON Schema instead.
+func runValidate(configPath string, args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	schema := fs.Bool("schema", false, "print a JSON Schema for clusters.yaml and exit")
+	skipProfiles := fs.Bool("skip-profiles", false, "do not check profiles against ~/.aws/config")
+	fs.Parse(args)
+
+	if *schema {
+		out, err := config.JSONSchema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to generate schema: %v%s\n", red, err, reset)
+			return 1
+		}
+		fmt.Println(string(out))
+		return 0
+	}
+
+	var profiles map[string]bool
+	if !*skipProfiles {
+		profiles = aws.Profiles()
+	}
+
+	path, problems, err := config.Validate(configPath, profiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, reset)
+		return 1
+	}
+	errs := 0
+	for _, p := range problems {
+		if p.Warning {
+			fmt.Fprintf(os.Stderr, "%s%s%s\n", yellow, p, reset)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, p, reset)
+		errs++
+	}
+	if errs == 0 {
+		fmt.Printf("%s%s is valid%s\n", green, path, reset)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "\n%d problem(s) in %s\n", errs, path)
+	return 1
+}